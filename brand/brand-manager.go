@@ -0,0 +1,192 @@
+// Package brand holds the QR code styles of tenants, loaded from the json
+// file named by QR_BRAND_FILE:
+//
+//	{
+//	  "acme": {
+//	    "hosts": ["s.acme.com"],
+//	    "foreground": "#003366",
+//	    "background": "#ffffff",
+//	    "rounded": true,
+//	    "logo": "./html/brand/acme.png",
+//	    "logo_scale": 0.2
+//	  }
+//	}
+//
+// A request picks a brand by name, else by its host, else QR_DEFAULT_BRAND.
+package brand
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/util"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Brand struct {
+	Name       string   `json:"-"`
+	Hosts      []string `json:"hosts"`
+	Foreground string   `json:"foreground"`
+	Background string   `json:"background"`
+	Rounded    bool     `json:"rounded"`
+	Logo       string   `json:"logo"`
+	LogoScale  float64  `json:"logo_scale"`
+
+	fg       color.RGBA
+	bg       color.RGBA
+	logo     image.Image
+	logoHash string
+}
+
+type BrandManager struct {
+	lock         sync.RWMutex
+	brands       map[string]*Brand
+	hosts        map[string]*Brand
+	defaultBrand string
+}
+
+var m *BrandManager
+var once sync.Once
+var logger *zap.Logger
+
+func GetInstance() *BrandManager {
+	once.Do(func() {
+		m = &BrandManager{}
+	})
+	return m
+}
+
+func (self *BrandManager) InitManager() error {
+	logger = log.GetInstance().GetLogger()
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	err := self.load(conf.Qr.BrandFile, conf.Qr.DefaultBrand)
+	if nil != err {
+		return err
+	}
+	config.GetInstance().Subscribe(self.onConfigChange)
+	return nil
+}
+
+// onConfigChange re-reads the brand file, a bad file keeps the old brands
+func (self *BrandManager) onConfigChange(change *config.ConfigChange) {
+	err := self.load(change.New.Qr.BrandFile, change.New.Qr.DefaultBrand)
+	if nil != err {
+		logger.Error("reload brand file err, keep running brands", zap.Error(err))
+	}
+}
+
+func (self *BrandManager) load(path, defaultBrand string) error {
+	brands := make(map[string]*Brand)
+	hosts := make(map[string]*Brand)
+	if "" != path {
+		content, err := ioutil.ReadFile(path)
+		if nil != err {
+			return err
+		}
+		err = json.Unmarshal(content, &brands)
+		if nil != err {
+			return errors.New("parse brand file " + path + " err: " + err.Error())
+		}
+		for name, brand := range brands {
+			brand.Name = name
+			err = brand.prepare()
+			if nil != err {
+				return errors.New("brand " + name + ": " + err.Error())
+			}
+			for _, host := range brand.Hosts {
+				hosts[strings.ToLower(host)] = brand
+			}
+		}
+	}
+	if _, ok := brands[defaultBrand]; "" != defaultBrand && !ok {
+		return errors.New("default brand not found: " + defaultBrand)
+	}
+	self.lock.Lock()
+	self.brands, self.hosts, self.defaultBrand = brands, hosts, defaultBrand
+	self.lock.Unlock()
+	if 0 != len(brands) {
+		logger.Info("brands loaded", zap.String("file", path), zap.Int("brands", len(brands)))
+	}
+	return nil
+}
+
+// prepare parses the colours and decodes the logo once
+func (self *Brand) prepare() error {
+	opts := util.DefaultQROptions()
+	self.fg, self.bg = opts.Foreground, opts.Background
+	var err error
+	if "" != self.Foreground {
+		self.fg, err = util.ParseColor(self.Foreground)
+		if nil != err {
+			return err
+		}
+	}
+	if "" != self.Background {
+		self.bg, err = util.ParseColor(self.Background)
+		if nil != err {
+			return err
+		}
+	}
+	if self.LogoScale < 0 || self.LogoScale > util.QR_MAX_LOGO_SCALE {
+		return errors.New("logo_scale must be between 0 and " + strconv.FormatFloat(util.QR_MAX_LOGO_SCALE, 'f', -1, 64))
+	}
+	if "" == self.Logo {
+		return nil
+	}
+	content, err := ioutil.ReadFile(self.Logo)
+	if nil != err {
+		return err
+	}
+	self.logo, _, err = image.Decode(bytes.NewReader(content))
+	if nil != err {
+		return errors.New("decode logo " + self.Logo + " err: " + err.Error())
+	}
+	// a replaced logo file must not be served from caches, even when its
+	// time was kept by the copy
+	h := fnv.New64a()
+	h.Write(content)
+	self.logoHash = strconv.FormatUint(h.Sum64(), 36)
+	return nil
+}
+
+// Apply sets the brand style on opts
+func (self *Brand) Apply(opts *util.QROptions) {
+	opts.Foreground, opts.Background = self.fg, self.bg
+	opts.Rounded = self.Rounded
+	opts.Logo, opts.LogoName, opts.LogoHash, opts.LogoScale = self.logo, self.Name, self.logoHash, self.LogoScale
+}
+
+// Get returns the brand called name, nil if unknown
+func (self *BrandManager) Get(name string) *Brand {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.brands[name]
+}
+
+// ForHost returns the brand serving host, else the default brand, else nil
+func (self *BrandManager) ForHost(host string) *Brand {
+	if h, _, err := net.SplitHostPort(host); nil == err {
+		host = h
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if brand := self.hosts[strings.ToLower(host)]; nil != brand {
+		return brand
+	}
+	return self.brands[self.defaultBrand]
+}
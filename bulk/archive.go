@@ -0,0 +1,170 @@
+package bulk
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/storage"
+	"github.com/service-kit/short-url/util"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ARCHIVE_MANIFEST = "manifest.csv"
+
+var manifestColumns = []string{"file", "short_url", "url", "original_url", "error"}
+var unsafeFileChars = regexp.MustCompile(`[^0-9A-Za-z_-]`)
+
+// ArchiveOptions selects the links of an archive, the listed Codes or else
+// every link tagged Tag, and how each is rendered
+type ArchiveOptions struct {
+	Codes          []string
+	Tag            string
+	Kind           string
+	Render         util.QROptions
+	ShortUrlHeader string
+	MaxLinks       int
+}
+
+// HasTag reports whether the comma separated tags include tag
+func HasTag(tags, tag string) bool {
+	for _, t := range splitTags(tags) {
+		if tag == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveLinks resolves the links of opts, unknown codes come back with
+// only ShortUrl set so the manifest can report them
+func ArchiveLinks(ctx context.Context, opts ArchiveOptions) ([]*common.ShortUrlInfo, error) {
+	if opts.MaxLinks < 1 {
+		opts.MaxLinks = DEFAULT_MAX_ROWS
+	}
+	var infos []*common.ShortUrlInfo
+	if 0 != len(opts.Codes) {
+		if len(opts.Codes) > opts.MaxLinks {
+			return nil, errors.New("at most " + strconv.Itoa(opts.MaxLinks) + " links per archive")
+		}
+		for _, code := range opts.Codes {
+			info, err := data.GetInstance().GetShortUrlInfo(ctx, code)
+			if nil != err || common.LS_DELETED == info.Status {
+				info = &common.ShortUrlInfo{ShortUrl: code}
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+	}
+	if "" == opts.Tag {
+		return nil, errors.New("codes or a tag are required")
+	}
+	now := util.GetCurrentSeconds()
+	err := storage.GetInstance().ForEachShortUrlInfo(ctx, DEFAULT_PAGE_SIZE, func(info *common.ShortUrlInfo) error {
+		// only links whose codes still redirect
+		if common.LS_ACTIVE != info.Status || info.IsExpired(now) || !HasTag(info.Tags, opts.Tag) {
+			return nil
+		}
+		if len(infos) == opts.MaxLinks {
+			return errors.New("more than " + strconv.Itoa(opts.MaxLinks) + " links tagged " + opts.Tag)
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	return infos, err
+}
+
+// fileName derives a unique archive entry name from the code
+func fileName(code, format string, used map[string]bool) string {
+	base := unsafeFileChars.ReplaceAllString(code, "_")
+	name := base + "." + format
+	for i := 2; used[name]; i++ {
+		name = base + "_" + strconv.Itoa(i) + "." + format
+	}
+	used[name] = true
+	return name
+}
+
+// WriteArchive streams a zip to w holding one image per link of infos and a
+// manifest mapping file names to short and original urls, returns the image
+// count. Links that can not be rendered are listed in the manifest with the error
+func WriteArchive(ctx context.Context, w io.Writer, infos []*common.ShortUrlInfo, opts ArchiveOptions) (int, error) {
+	if "" == opts.Kind {
+		opts.Kind = util.BARCODE_QR
+	}
+	render := opts.Render.ForKind(opts.Kind)
+	// png, jpg and pdf are compressed already
+	method := zip.Store
+	if util.QR_FORMAT_SVG == render.Format {
+		method = zip.Deflate
+	}
+	archive := zip.NewWriter(w)
+	// an http response is flushed after every image, so the download starts
+	// while the rest renders
+	flusher, _ := w.(interface{ Flush() })
+	used := map[string]bool{ARCHIVE_MANIFEST: true}
+	manifest := [][]string{manifestColumns}
+	now := time.Now()
+	count := 0
+	for _, info := range infos {
+		if err := ctx.Err(); nil != err {
+			return count, err
+		}
+		url := opts.ShortUrlHeader + info.ShortUrl
+		if "" == info.OriginalUrl {
+			manifest = append(manifest, []string{"", info.ShortUrl, url, "", common.ERROR_NOT_EXIST})
+			continue
+		}
+		img, err := util.BuildBarcode(opts.Kind, url, render)
+		if nil != err {
+			manifest = append(manifest, []string{"", info.ShortUrl, url, info.OriginalUrl, err.Error()})
+			continue
+		}
+		name := fileName(info.ShortUrl, render.Format, used)
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: now})
+		if nil == err {
+			_, err = f.Write(img)
+		}
+		if nil == err && nil != flusher {
+			err = archive.Flush()
+			flusher.Flush()
+		}
+		if nil != err {
+			return count, err
+		}
+		count++
+		manifest = append(manifest, []string{name, info.ShortUrl, url, info.OriginalUrl, ""})
+	}
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: ARCHIVE_MANIFEST, Method: zip.Deflate, Modified: now})
+	if nil != err {
+		return count, err
+	}
+	writer := csv.NewWriter(f)
+	for _, record := range manifest {
+		cells := make([]string, len(record))
+		for i, cell := range record {
+			cells[i] = manifestCell(cell)
+		}
+		writer.Write(cells)
+	}
+	writer.Flush()
+	if err = writer.Error(); nil != err {
+		return count, err
+	}
+	return count, archive.Close()
+}
+
+// manifestCell quotes cells a spreadsheet would take for a formula, urls
+// and tags come from users
+func manifestCell(cell string) string {
+	if "" != cell && strings.ContainsRune("=+-@", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
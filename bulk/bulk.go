@@ -0,0 +1,282 @@
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/util"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_CSV    = "csv"
+	FORMAT_NDJSON = "ndjson"
+)
+
+const (
+	DEFAULT_MAX_ROWS   = 10000
+	DEFAULT_BATCH_SIZE = 100
+	MAX_LINE_SIZE      = 1024 * 1024
+)
+
+var csvColumns = []string{"original_url", "short_url", "expire_time", "tags", "interstitial", "password"}
+var resultColumns = []string{"line", "original_url", "short_url", "url", "code", "error"}
+
+// Row is one requested link, Err holds the parse error of the row if any
+type Row struct {
+	Line         int
+	OriginalUrl  string
+	ShortUrl     string
+	ExpireTime   int64
+	Tags         []string
+	Interstitial bool
+	Password     string
+	Err          error
+}
+
+// Result is the outcome of one Row, written back in the request format
+type Result struct {
+	Line        int    `json:"line"`
+	OriginalUrl string `json:"original_url"`
+	ShortUrl    string `json:"short_url"`
+	Url         string `json:"url,omitempty"`
+	Code        string `json:"code"`
+	Error       string `json:"error,omitempty"`
+}
+
+type jsonRow struct {
+	OriginalUrl  string      `json:"original_url"`
+	ShortUrl     string      `json:"short_url"`
+	ExpireTime   interface{} `json:"expire_time"`
+	Tags         []string    `json:"tags"`
+	Interstitial bool        `json:"interstitial"`
+	Password     string      `json:"password"`
+}
+
+// DetectFormat picks the format from an explicit name or the content type
+func DetectFormat(contentType, format string) (string, error) {
+	if "" == format {
+		format = contentType
+		if i := strings.Index(format, ";"); i >= 0 {
+			format = format[:i]
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case FORMAT_CSV, "text/csv", "application/csv":
+		return FORMAT_CSV, nil
+	case FORMAT_NDJSON, "jsonl", "application/x-ndjson", "application/ndjson", "application/jsonl", "application/json":
+		return FORMAT_NDJSON, nil
+	}
+	return "", errors.New("unsupported format " + format)
+}
+
+// ParseExpire accepts unix seconds or RFC3339, empty means never
+func ParseExpire(str string) (int64, error) {
+	str = strings.TrimSpace(str)
+	if "" == str {
+		return 0, nil
+	}
+	if sec, err := strconv.ParseInt(str, 10, 64); nil == err {
+		return sec, nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if nil != err {
+		return 0, errors.New("invalid expire time " + str)
+	}
+	return t.Unix(), nil
+}
+
+func splitTags(str string) []string {
+	var tags []string
+	for _, tag := range strings.Split(str, ",") {
+		tag = strings.TrimSpace(tag)
+		if "" != tag {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// ReadRows parses at most maxRows rows from r
+func ReadRows(r io.Reader, format string, maxRows int) ([]*Row, error) {
+	if FORMAT_CSV == format {
+		return readCsvRows(r, maxRows)
+	}
+	return readJsonRows(r, maxRows)
+}
+
+func readCsvRows(r io.Reader, maxRows int) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	index := map[string]int{}
+	for i, column := range csvColumns {
+		index[column] = i
+	}
+	field := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var rows []*Row
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			return nil, err
+		}
+		if 1 == line && "original_url" == strings.TrimSpace(record[0]) {
+			index = map[string]int{}
+			for i, column := range record {
+				index[strings.TrimSpace(column)] = i
+			}
+			continue
+		}
+		if len(rows) >= maxRows {
+			return nil, fmt.Errorf("too many rows, max %d", maxRows)
+		}
+		row := &Row{Line: line, OriginalUrl: field(record, "original_url"), ShortUrl: field(record, "short_url")}
+		row.Tags = splitTags(field(record, "tags"))
+		row.Interstitial = "1" == field(record, "interstitial")
+		row.Password = field(record, "password")
+		row.ExpireTime, row.Err = ParseExpire(field(record, "expire_time"))
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readJsonRows(r io.Reader, maxRows int) ([]*Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_LINE_SIZE)
+	var rows []*Row
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if "" == text {
+			continue
+		}
+		if len(rows) >= maxRows {
+			return nil, fmt.Errorf("too many rows, max %d", maxRows)
+		}
+		row := &Row{Line: line}
+		rows = append(rows, row)
+		var jr jsonRow
+		err := json.Unmarshal([]byte(text), &jr)
+		if nil != err {
+			row.Err = err
+			continue
+		}
+		row.OriginalUrl = strings.TrimSpace(jr.OriginalUrl)
+		row.ShortUrl = strings.TrimSpace(jr.ShortUrl)
+		row.Tags = jr.Tags
+		row.Interstitial = jr.Interstitial
+		row.Password = jr.Password
+		switch expire := jr.ExpireTime.(type) {
+		case nil:
+		case float64:
+			row.ExpireTime = int64(expire)
+		case string:
+			row.ExpireTime, row.Err = ParseExpire(expire)
+		default:
+			row.Err = errors.New("invalid expire time")
+		}
+	}
+	return rows, scanner.Err()
+}
+
+// validateRow checks the row itself, the url policy runs on create
+func validateRow(row *Row, now int64) error {
+	if nil != row.Err {
+		return row.Err
+	}
+	if "" == row.OriginalUrl {
+		return errors.New("original_url is empty")
+	}
+	if "" != row.ShortUrl {
+		if err := data.CheckAlias(row.ShortUrl); nil != err {
+			return err
+		}
+	}
+	if 0 != row.ExpireTime && row.ExpireTime <= now {
+		return errors.New("expire_time is in the past")
+	}
+	if len(row.Password) > util.PASSWORD_MAX_LEN {
+		return errors.New("password is longer than " + strconv.Itoa(util.PASSWORD_MAX_LEN))
+	}
+	return nil
+}
+
+// Create validates every row and registers the valid ones, writing
+// batchSize links per storage transaction
+func Create(ctx context.Context, rows []*Row, batchSize int, shortUrlHeader string) []Result {
+	results := make([]Result, len(rows))
+	now := util.GetCurrentSeconds()
+	var infos []*common.ShortUrlInfo
+	var pending []int
+	for i, row := range rows {
+		results[i] = Result{Line: row.Line, OriginalUrl: row.OriginalUrl, ShortUrl: row.ShortUrl, Code: common.FAIL}
+		err := validateRow(row, now)
+		if nil != err {
+			results[i].Error = err.Error()
+			continue
+		}
+		info := new(common.ShortUrlInfo)
+		info.OriginalUrl = row.OriginalUrl
+		info.ShortUrl = row.ShortUrl
+		info.ExpireTime = row.ExpireTime
+		info.Tags = strings.Join(row.Tags, ",")
+		info.Interstitial = row.Interstitial
+		if "" != row.Password {
+			info.PasswordHash, err = util.HashPassword(ctx, row.Password)
+			if nil != err {
+				results[i].Error = err.Error()
+				continue
+			}
+		}
+		infos = append(infos, info)
+		pending = append(pending, i)
+	}
+	errs := data.GetInstance().CreateShortUrls(ctx, infos, batchSize)
+	for k, i := range pending {
+		results[i].OriginalUrl, results[i].ShortUrl = infos[k].OriginalUrl, infos[k].ShortUrl
+		if nil != errs[k] {
+			results[i].Error = errs[k].Error()
+			continue
+		}
+		results[i].Code = common.SUCCESS
+		results[i].Url = shortUrlHeader + infos[k].ShortUrl
+	}
+	return results
+}
+
+// WriteResults writes results to w in format
+func WriteResults(w io.Writer, format string, results []Result) error {
+	if FORMAT_CSV == format {
+		writer := csv.NewWriter(w)
+		writer.Write(resultColumns)
+		for _, res := range results {
+			writer.Write([]string{strconv.Itoa(res.Line), res.OriginalUrl, res.ShortUrl, res.Url, res.Code, res.Error})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	encoder := json.NewEncoder(w)
+	for _, res := range results {
+		err := encoder.Encode(res)
+		if nil != err {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/storage"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PAGE_SIZE = 500
+
+var exportColumns = []string{"short_url", "original_url", "status", "reason", "tags", "expire_time", "clicks", "create_time", "update_time", "interstitial", "password_hash"}
+
+// ImportStat counts what Import did with the records it read
+type ImportStat struct {
+	Imported    int
+	Skipped     int
+	Overwritten int
+}
+
+func infoToRecord(info *common.ShortUrlInfo) []string {
+	return []string{
+		info.ShortUrl,
+		info.OriginalUrl,
+		strconv.Itoa(info.Status),
+		info.Reason,
+		info.Tags,
+		strconv.FormatInt(info.ExpireTime, 10),
+		strconv.FormatInt(info.Clicks, 10),
+		strconv.FormatInt(info.CreateTime, 10),
+		strconv.FormatInt(info.UpdateTime, 10),
+		boolField(info.Interstitial),
+		info.PasswordHash,
+	}
+}
+
+func boolField(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func recordToInfo(index map[string]int, record []string) (*common.ShortUrlInfo, error) {
+	field := func(column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(column string) (int64, error) {
+		str := field(column)
+		if "" == str {
+			return 0, nil
+		}
+		return strconv.ParseInt(str, 10, 64)
+	}
+	info := new(common.ShortUrlInfo)
+	info.ShortUrl = field("short_url")
+	info.OriginalUrl = field("original_url")
+	info.Reason = field("reason")
+	info.Tags = field("tags")
+	info.PasswordHash = field("password_hash")
+	status, err := number("status")
+	if nil != err {
+		return nil, err
+	}
+	info.Status = int(status)
+	if info.ExpireTime, err = number("expire_time"); nil != err {
+		return nil, err
+	}
+	if info.Clicks, err = number("clicks"); nil != err {
+		return nil, err
+	}
+	if info.CreateTime, err = number("create_time"); nil != err {
+		return nil, err
+	}
+	if info.UpdateTime, err = number("update_time"); nil != err {
+		return nil, err
+	}
+	interstitial, err := number("interstitial")
+	if nil != err {
+		return nil, err
+	}
+	info.Interstitial = 0 != interstitial
+	return info, nil
+}
+
+// Export streams every stored link to w in format, returns the link count
+func Export(ctx context.Context, w io.Writer, format string, pageSize int) (int, error) {
+	if pageSize < 1 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	count := 0
+	if FORMAT_CSV == format {
+		writer := csv.NewWriter(w)
+		writer.Write(exportColumns)
+		err := storage.GetInstance().ForEachShortUrlInfo(ctx, pageSize, func(info *common.ShortUrlInfo) error {
+			count++
+			return writer.Write(infoToRecord(info))
+		})
+		writer.Flush()
+		if nil == err {
+			err = writer.Error()
+		}
+		return count, err
+	}
+	encoder := json.NewEncoder(w)
+	err := storage.GetInstance().ForEachShortUrlInfo(ctx, pageSize, func(info *common.ShortUrlInfo) error {
+		count++
+		return encoder.Encode(info)
+	})
+	return count, err
+}
+
+func readInfos(r io.Reader, format string) ([]*common.ShortUrlInfo, error) {
+	var infos []*common.ShortUrlInfo
+	if FORMAT_CSV == format {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		index := map[string]int{}
+		for i, column := range exportColumns {
+			index[column] = i
+		}
+		for line := 1; ; line++ {
+			record, err := reader.Read()
+			if io.EOF == err {
+				return infos, nil
+			}
+			if nil != err {
+				return nil, err
+			}
+			if 1 == line && "short_url" == strings.TrimSpace(record[0]) {
+				index = map[string]int{}
+				for i, column := range record {
+					index[strings.TrimSpace(column)] = i
+				}
+				continue
+			}
+			info, err := recordToInfo(index, record)
+			if nil != err {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			infos = append(infos, info)
+		}
+	}
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		info := new(common.ShortUrlInfo)
+		err := decoder.Decode(info)
+		if io.EOF == err {
+			return infos, nil
+		}
+		if nil != err {
+			return nil, fmt.Errorf("record %d: %v", line, err)
+		}
+		infos = append(infos, info)
+	}
+}
+
+func checkInfo(info *common.ShortUrlInfo) error {
+	if "" == info.ShortUrl || "" == info.OriginalUrl {
+		return errors.New("short_url and original_url are required")
+	}
+	if err := data.CheckAlias(info.ShortUrl); nil != err {
+		return err
+	}
+	if info.Status < common.LS_ACTIVE || info.Status > common.LS_DELETED {
+		return errors.New("invalid status " + strconv.Itoa(info.Status))
+	}
+	return nil
+}
+
+// Import reads links from r in format and stores them with their metadata
+// and click counters, policy decides what happens to codes already in use;
+// with CP_FAIL nothing is written when any code conflicts
+func Import(ctx context.Context, r io.Reader, format, policy string) (ImportStat, error) {
+	var stat ImportStat
+	if common.CP_SKIP != policy && common.CP_OVERWRITE != policy && common.CP_FAIL != policy {
+		return stat, errors.New("unknown conflict policy " + policy)
+	}
+	infos, err := readInfos(r, format)
+	if nil != err {
+		return stat, err
+	}
+	conflicts := make([]bool, len(infos))
+	for i, info := range infos {
+		err = checkInfo(info)
+		if nil == err {
+			err = data.GetInstance().CheckOriginalUrl(ctx, info)
+		}
+		if nil != err {
+			return stat, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		exist, _ := data.GetInstance().GetShortUrlInfo(ctx, info.ShortUrl)
+		conflicts[i] = nil != exist
+		if conflicts[i] && common.CP_FAIL == policy {
+			return stat, fmt.Errorf("record %d: %s %s", i+1, info.ShortUrl, common.ERROR_EXIST)
+		}
+	}
+	for i, info := range infos {
+		if conflicts[i] && common.CP_SKIP == policy {
+			stat.Skipped++
+			continue
+		}
+		err = data.GetInstance().ReplaceShortUrl(ctx, info)
+		if nil != err {
+			return stat, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if conflicts[i] {
+			stat.Overwritten++
+		} else {
+			stat.Imported++
+		}
+	}
+	return stat, nil
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/service"
+	"github.com/service-kit/short-url/storage"
+	"strings"
+)
+
+// secretKeys are masked by config check -v, keys named like them are too
+var secretKeys = map[string]bool{
+	"ADMIN_TOKEN":   true,
+	"COOKIE_SECRET": true,
+	"DB_PASSWD":     true,
+	"REDIS_PASSWD":  true,
+}
+
+func isSecretKey(key string) bool {
+	return secretKeys[key] || strings.Contains(key, "PASSWD") || strings.Contains(key, "TOKEN") || strings.Contains(key, "SECRET")
+}
+
+func runMigrate(args []string) error {
+	err := service.InitStorageManager()
+	if nil != err {
+		return err
+	}
+	defer finish()
+	if !storage.GetInstance().IsMysqlOn() {
+		fmt.Println("mysql switch off, nothing to migrate")
+		return nil
+	}
+	fmt.Println("schema up to date")
+	return nil
+}
+
+func runConfig(args []string) error {
+	verbose := 2 == len(args) && "-v" == args[1]
+	if 0 == len(args) || "check" != args[0] || (len(args) > 1 && !verbose) {
+		return errors.New("usage: config check [-v]")
+	}
+	err := service.InitConfigManager()
+	if confErr, ok := err.(*config.ConfigError); ok {
+		for _, problem := range confErr.Problems {
+			fmt.Println(problem)
+		}
+		return fmt.Errorf("%d problems found", len(confErr.Problems))
+	}
+	if nil != err {
+		return err
+	}
+	defer finish()
+	confFile := config.GetInstance().GetConfigFile()
+	if "" == confFile {
+		confFile = "none, environment and defaults only"
+	}
+	fmt.Println("config file:", confFile)
+	if profile := config.GetInstance().GetProfile(); "" != profile {
+		fmt.Println("config profile:", profile)
+	}
+	if verbose {
+		for _, key := range config.Keys() {
+			value, _ := config.GetInstance().GetConfig(key)
+			if "" != value && isSecretKey(key) {
+				value = "******"
+			}
+			fmt.Printf("%s=%s\n", key, value)
+		}
+	}
+	fmt.Println("config ok")
+	return nil
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/service"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":   {"serve", runServe},
+		"create":  {"create <url> [-alias code] [-expire time] [-tags a,b]", runCreate},
+		"resolve": {"resolve <code> [-v]", runResolve},
+		"list":    {"list [-status active|disabled|deleted|all] [-tag tag]", runList},
+		"delete":  {"delete <code>", runDelete},
+		"disable": {"disable <code> [reason]", runDisable},
+		"restore": {"restore <code>", runRestore},
+		"purge":   {"purge <code>", runPurge},
+		"export":  {"export [-format ndjson|csv] [-out file]", runExport},
+		"import":  {"import [-format ndjson|csv] [-policy skip|overwrite|fail] [file]", runImport},
+		"archive": {"archive [-tag tag] [-barcode qr] [-format png] [-size px] [-ecc L] [-margin n] [-brand name] [-out file] [code...]", runArchive},
+		"migrate": {"migrate", runMigrate},
+		"config":  {"config check [-v]", runConfig},
+	}
+}
+
+// globalFlags are accepted before or after the sub command
+var globalFlags = []string{"config", "profile"}
+
+// splitGlobalFlags takes the global flags out of args wherever they are,
+// as -name value, --name value or --name=value, up to a "--"
+func splitGlobalFlags(args []string) (map[string]string, []string, error) {
+	values := make(map[string]string)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if "--" == arg {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		known := false
+		for _, flagName := range globalFlags {
+			known = known || flagName == name
+		}
+		if !strings.HasPrefix(arg, "-") || !known {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return nil, nil, errors.New("flag needs an argument: -" + name)
+			}
+			i++
+			value = args[i]
+		}
+		values[name] = value
+	}
+	return values, rest, nil
+}
+
+// Run executes the sub command in args and returns the process exit code,
+// no sub command means serve
+func Run(args []string) int {
+	flags, args, err := splitGlobalFlags(args)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		printUsage()
+		return 2
+	}
+	config.GetInstance().SetConfigFile(flags["config"])
+	config.GetInstance().SetProfile(flags["profile"])
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if "help" == name || "-h" == name || "--help" == name {
+		printUsage()
+		return 0
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return 2
+	}
+	err = cmd.run(args)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, name+":", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: short-url [--config file] [--profile name] <command> [args]")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// parseArgs parses fs allowing flags before and after positional args
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if nil != err {
+			return nil, err
+		}
+		args = fs.Args()
+		if 0 == len(args) {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func initBase() error {
+	return service.InitBaseManager()
+}
+
+func finish() {
+	log.GetInstance().FinishProcess()
+}
+
+func runServe(args []string) error {
+	service.StartService()
+	return nil
+}
+
+func codeArg(args []string) (string, error) {
+	if len(args) < 1 || "" == args[0] {
+		return "", errors.New("missing short url code")
+	}
+	return args[0], nil
+}
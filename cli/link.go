@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/service-kit/short-url/bulk"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/storage"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var statusNames = map[int]string{
+	common.LS_ACTIVE:   "active",
+	common.LS_DISABLED: "disabled",
+	common.LS_DELETED:  "deleted",
+}
+
+func shortUrlHeader() string {
+	return config.GetInstance().Config().ShortUrlHeader
+}
+
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	alias := fs.String("alias", "", "custom short url code")
+	expire := fs.String("expire", "", "expire time, unix seconds or RFC3339")
+	tags := fs.String("tags", "", "comma separated tags")
+	interstitial := fs.Bool("interstitial", false, "show the preview page before redirecting")
+	password := fs.String("password", "", "password asked before redirecting")
+	positional, err := parseArgs(fs, args)
+	if nil != err {
+		return err
+	}
+	if 1 != len(positional) {
+		return errors.New("need exactly one url")
+	}
+	row := &bulk.Row{Line: 1, OriginalUrl: positional[0], ShortUrl: *alias, Interstitial: *interstitial, Password: *password}
+	row.ExpireTime, row.Err = bulk.ParseExpire(*expire)
+	if "" != *tags {
+		row.Tags = strings.Split(*tags, ",")
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	res := bulk.Create(context.Background(), []*bulk.Row{row}, 1, shortUrlHeader())[0]
+	if common.SUCCESS != res.Code {
+		return errors.New(res.Error)
+	}
+	fmt.Println(res.Url)
+	return nil
+}
+
+func runResolve(args []string) error {
+	fs := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "print all link fields as json")
+	positional, err := parseArgs(fs, args)
+	if nil != err {
+		return err
+	}
+	code, err := codeArg(positional)
+	if nil != err {
+		return err
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	info, err := data.GetInstance().GetShortUrlInfo(context.Background(), code)
+	if nil != err {
+		return err
+	}
+	if !*verbose {
+		fmt.Println(info.OriginalUrl)
+		return nil
+	}
+	info.Clicks = data.GetInstance().GetClicks(context.Background(), info)
+	out, err := json.MarshalIndent(info, "", "  ")
+	if nil != err {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	status := fs.String("status", "active", "active, disabled, deleted or all")
+	tag := fs.String("tag", "", "only links carrying this tag")
+	err := fs.Parse(args)
+	if nil != err {
+		return err
+	}
+	want := -1
+	for s, name := range statusNames {
+		if name == *status {
+			want = s
+		}
+	}
+	if -1 == want && "all" != *status {
+		return errors.New("unknown status " + *status)
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CODE\tSTATUS\tCLICKS\tCREATED\tTAGS\tORIGINAL URL")
+	err = storage.GetInstance().ForEachShortUrlInfo(context.Background(), bulk.DEFAULT_PAGE_SIZE, func(info *common.ShortUrlInfo) error {
+		if -1 != want && want != info.Status {
+			return nil
+		}
+		if "" != *tag && !bulk.HasTag(info.Tags, *tag) {
+			return nil
+		}
+		created := "-"
+		if 0 != info.CreateTime {
+			created = time.Unix(info.CreateTime, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", info.ShortUrl, statusNames[info.Status], info.Clicks, created, info.Tags, info.OriginalUrl)
+		return nil
+	})
+	w.Flush()
+	return err
+}
+
+func runDelete(args []string) error {
+	code, err := codeArg(args)
+	if nil != err {
+		return err
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	return data.GetInstance().DeleteShortUrl(context.Background(), code)
+}
+
+func runDisable(args []string) error {
+	code, err := codeArg(args)
+	if nil != err {
+		return err
+	}
+	reason := ""
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	return data.GetInstance().DisableShortUrl(context.Background(), code, reason)
+}
+
+func runRestore(args []string) error {
+	code, err := codeArg(args)
+	if nil != err {
+		return err
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	return data.GetInstance().RestoreShortUrl(context.Background(), code)
+}
+
+func runPurge(args []string) error {
+	code, err := codeArg(args)
+	if nil != err {
+		return err
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	return data.GetInstance().PurgeShortUrl(context.Background(), code)
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/service-kit/short-url/brand"
+	"github.com/service-kit/short-url/bulk"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/util"
+	"io"
+	"os"
+)
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", bulk.FORMAT_NDJSON, "output format, ndjson or csv")
+	out := fs.String("out", "", "output file, stdout when empty")
+	pageSize := fs.Int("page", bulk.DEFAULT_PAGE_SIZE, "links read per page")
+	positional, err := parseArgs(fs, args)
+	if nil != err {
+		return err
+	}
+	if 0 != len(positional) {
+		return errors.New("unexpected argument " + positional[0])
+	}
+	*format, err = bulk.DetectFormat("", *format)
+	if nil != err {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if "" != *out {
+		f, err := os.Create(*out)
+		if nil != err {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	count, err := bulk.Export(context.Background(), w, *format, *pageSize)
+	fmt.Fprintf(os.Stderr, "exported %d links\n", count)
+	return err
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", bulk.FORMAT_NDJSON, "input format, ndjson or csv")
+	policy := fs.String("policy", common.CP_SKIP, "conflict policy, skip, overwrite or fail")
+	positional, err := parseArgs(fs, args)
+	if nil != err {
+		return err
+	}
+	if len(positional) > 1 {
+		return errors.New("need at most one file")
+	}
+	*format, err = bulk.DetectFormat("", *format)
+	if nil != err {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if 1 == len(positional) {
+		f, err := os.Open(positional[0])
+		if nil != err {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	stat, err := bulk.Import(context.Background(), r, *format, *policy)
+	fmt.Fprintf(os.Stderr, "imported %d, overwritten %d, skipped %d\n", stat.Imported, stat.Overwritten, stat.Skipped)
+	return err
+}
+
+func runArchive(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	tag := fs.String("tag", "", "archive the links carrying this tag instead of listed codes")
+	kind := fs.String("barcode", util.BARCODE_QR, "qr, datamatrix, aztec, pdf417 or code128")
+	format := fs.String("format", util.QR_FORMAT_PNG, "png, jpg, svg or pdf")
+	size := fs.Int("size", util.QR_DEFAULT_SIZE, "image width in pixels, points for pdf")
+	ecc := fs.String("ecc", "L", "error correction L, M, Q or H")
+	margin := fs.Int("margin", util.QR_DEFAULT_MARGIN, "quiet zone in modules")
+	brandName := fs.String("brand", "", "brand from QR_BRAND_FILE")
+	out := fs.String("out", "", "output file, stdout when empty")
+	codes, err := parseArgs(fs, args)
+	if nil != err {
+		return err
+	}
+	opts := bulk.ArchiveOptions{Codes: codes, Tag: *tag}
+	if opts.Kind, err = util.ParseBarcodeKind(*kind); nil != err {
+		return err
+	}
+	if err = initBase(); nil != err {
+		return err
+	}
+	defer finish()
+	opts.Render = util.DefaultQROptions()
+	if "" != *brandName {
+		b := brand.GetInstance().Get(*brandName)
+		if nil == b {
+			return errors.New("unknown brand: " + *brandName)
+		}
+		b.Apply(&opts.Render)
+	}
+	if opts.Render.Format, err = util.ParseQRFormat(*format); nil != err {
+		return err
+	}
+	if opts.Render.Level, err = util.ParseQRLevel(*ecc); nil != err {
+		return err
+	}
+	opts.Render.Size, opts.Render.Margin = *size, *margin
+	conf := config.GetInstance().Config()
+	opts.ShortUrlHeader, opts.MaxLinks = conf.ShortUrlHeader, conf.BulkMaxRows
+	ctx := context.Background()
+	links, err := bulk.ArchiveLinks(ctx, opts)
+	if nil != err {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if "" != *out {
+		f, err := os.Create(*out)
+		if nil != err {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	count, err := bulk.WriteArchive(ctx, w, links, opts)
+	fmt.Fprintf(os.Stderr, "archived %d codes\n", count)
+	return err
+}
@@ -12,13 +12,58 @@ const (
 	ERROR_VERIFY_NOT_PASS  = "verify not pass"
 	ERROR_CAN_NOT_REGISTER = "can not register"
 	ERROR_EXIST            = "register exist"
+	ERROR_NOT_EXIST        = "register not exist"
+	ERROR_INVALID_PARAM    = "invalid param"
+	ERROR_EXPIRED          = "register expired"
 )
 
+// ORIGINAL_URL_MAX_LENGTH is the size of the original_url column, a string
+// primary key is a varchar(255)
+const ORIGINAL_URL_MAX_LENGTH = 255
+
+// ShortUrlInfo is looked up by its short url alone, which has a unique index
+// of its own besides the primary key
 type ShortUrlInfo struct {
-	OriginalUrl string `gorm:"primary_key;auto_increment:false"`
-	ShortUrl    string `gorm:"primary_key;auto_increment:false"`
+	OriginalUrl string `gorm:"primary_key;auto_increment:false" json:"original_url"`
+	ShortUrl    string `gorm:"primary_key;auto_increment:false;unique_index:uix_short_url" json:"short_url"`
+	Status      int    `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+	Tags        string `json:"tags,omitempty"`
+	// Interstitial shows the preview page instead of redirecting
+	Interstitial bool `json:"interstitial,omitempty"`
+	// PasswordHash of util.HashPassword gates the redirect when set
+	PasswordHash string `json:"password_hash,omitempty"`
+	ExpireTime   int64  `json:"expire_time,omitempty"`
+	Clicks       int64  `json:"clicks"`
+	CreateTime   int64  `json:"create_time"`
+	UpdateTime   int64  `json:"update_time"`
+}
+
+// IsExpired reports whether the link has an expire time that has passed
+func (self *ShortUrlInfo) IsExpired(now int64) bool {
+	return 0 != self.ExpireTime && now >= self.ExpireTime
+}
+
+// Plain reports whether the link redirects straight away, only plain links
+// are shared by requests for the same original url
+func (self *ShortUrlInfo) Plain() bool {
+	return !self.Interstitial && "" == self.PasswordHash
 }
 
+// link status, deleted links are kept until purged
+const (
+	LS_ACTIVE   = 0
+	LS_DISABLED = 1
+	LS_DELETED  = 2
+)
+
+// import conflict policy
+const (
+	CP_SKIP      = "skip"
+	CP_OVERWRITE = "overwrite"
+	CP_FAIL      = "fail"
+)
+
 const (
 	SWITHC_ON  = 1
 	SWITHC_OFF = 0
@@ -30,7 +75,71 @@ const (
 	LL_ERROR = "error"
 )
 
+// log encoding
+const (
+	LE_JSON    = "json"
+	LE_CONSOLE = "console"
+)
+
+// access log format
+const (
+	AF_COMBINED = "combined"
+	AF_JSON     = "json"
+)
+
+// log console output, besides the log file
+const (
+	LC_NONE   = ""
+	LC_STDOUT = "stdout"
+	LC_STDERR = "stderr"
+)
+
+// trace span exporter
+const (
+	TE_NONE   = ""
+	TE_STDOUT = "stdout"
+	TE_FILE   = "file"
+)
+
+// qr cache second tier
+const (
+	QT_NONE  = ""
+	QT_DISK  = "disk"
+	QT_REDIS = "redis"
+)
+
+// trailing slash policy of original url paths
+const (
+	TS_KEEP  = "keep"
+	TS_ADD   = "add"
+	TS_STRIP = "strip"
+)
+
+// links showing the preview page before redirecting, besides the ones
+// asking for it themselves
+const (
+	IM_NONE      = "none"
+	IM_UNTRUSTED = "untrusted"
+	IM_ALL       = "all"
+)
+
+// policy for original urls pointing at a short url
+const (
+	CH_REJECT  = "reject"
+	CH_FLATTEN = "flatten"
+)
+
 const (
 	SHORT_URL_HEADER = "http://127.0.0.1/"
 	FAVICON_ICO      = "favicon.ico"
+	STATIC_DIR       = "./html"
+	// SHORTENERS are well known url shorteners, links to them hide their target
+	SHORTENERS = "bit.ly,bitly.com,tinyurl.com,t.co,goo.gl,ow.ly,is.gd,v.gd,buff.ly,rebrand.ly,cutt.ly,shorturl.at,rb.gy,tiny.cc,t.ly,s.id"
+)
+
+// pages of the service which are no short links, links may point at them
+const (
+	PAYLOAD_PATH       = "/qr/payload"
+	PAYLOAD_OPEN_PATH  = "/qr/payload/open"
+	STATIC_PATH_PREFIX = "/static/"
 )
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"github.com/service-kit/short-url/common"
+	"sort"
+)
+
+const (
+	DEFAULT_CONFIG_FILE = "./conf/short_url_conf.ini"
+	// ENV_CONFIG_FILE names the config file when --config is not given
+	ENV_CONFIG_FILE = "SHORTURL_CONFIG"
+	// ENV_PREFIX + key overrides any key, e.g. SHORTURL_REDIS_ADDR
+	ENV_PREFIX = "SHORTURL_"
+	// ENV_PROFILE selects the profile when --profile is not given
+	ENV_PROFILE = "SHORTURL_PROFILE"
+	// PROFILE_KEY selects the profile from the default section of the file
+	PROFILE_KEY = "PROFILE"
+	// INCLUDE_KEY lists more files merged over the main one, e.g. secrets
+	INCLUDE_KEY = "INCLUDE"
+	// key + SECRET_FILE_SUFFIX names a file holding the value of key
+	SECRET_FILE_SUFFIX = "_FILE"
+)
+
+// keySections maps a key to its section and the name it has there,
+// e.g. REDIS_ADDR may be written as ADDR in [redis]
+var keySections = map[string][2]string{
+	"SHORT_URL_HTTP_ADDR":     {"http", "ADDR"},
+	"SHORT_URL_HEADER":        {"http", "HEADER"},
+	"DISABLED_HTML":           {"http", "DISABLED_HTML"},
+	"BLOCKED_HTML":            {"http", "BLOCKED_HTML"},
+	"TEMPLATE_DIR":            {"http", "TEMPLATE_DIR"},
+	"INTERSTITIAL":            {"http", "INTERSTITIAL"},
+	"COOKIE_SECRET":           {"http", "COOKIE_SECRET"},
+	"PASSWORD_TTL":            {"http", "PASSWORD_TTL"},
+	"STATIC_DIR":              {"http", "STATIC_DIR"},
+	"STATIC_EMBED":            {"http", "STATIC_EMBED"},
+	"ADMIN_TOKEN":             {"http", "ADMIN_TOKEN"},
+	"BULK_MAX_ROWS":           {"http", "BULK_MAX_ROWS"},
+	"BULK_BATCH_SIZE":         {"http", "BULK_BATCH_SIZE"},
+	"REDIS_ADDR":              {"redis", "ADDR"},
+	"REDIS_PASSWD":            {"redis", "PASSWD"},
+	"REDIS_POOL_MAX_IDLE":     {"redis", "POOL_MAX_IDLE"},
+	"REDIS_POOL_MAX_ACTIVE":   {"redis", "POOL_MAX_ACTIVE"},
+	"REDIS_POOL_IDLE_TIMEOUT": {"redis", "POOL_IDLE_TIMEOUT"},
+	"MYSQL_SWITCH":            {"mysql", "SWITCH"},
+	"DB_ADDR":                 {"mysql", "ADDR"},
+	"DB_USER":                 {"mysql", "USER"},
+	"DB_PASSWD":               {"mysql", "PASSWD"},
+	"DB_DBNAME":               {"mysql", "DBNAME"},
+	"LOG_FILE_PATH":           {"log", "FILE_PATH"},
+	"LOG_LEVEL":               {"log", "LEVEL"},
+	"LOG_NAME":                {"log", "NAME"},
+	"LOG_ENCODING":            {"log", "ENCODING"},
+	"LOG_CONSOLE":             {"log", "CONSOLE"},
+	"LOG_MAX_SIZE":            {"log", "MAX_SIZE"},
+	"LOG_MAX_BACKUPS":         {"log", "MAX_BACKUPS"},
+	"LOG_MAX_AGE":             {"log", "MAX_AGE"},
+	"LOG_COMPRESS":            {"log", "COMPRESS"},
+	"ACCESS_LOG_PATH":         {"log", "ACCESS_PATH"},
+	"ACCESS_LOG_FORMAT":       {"log", "ACCESS_FORMAT"},
+	"ACCESS_LOG_SAMPLE":       {"log", "ACCESS_SAMPLE"},
+	"ACCESS_LOG_TRUST_PROXY":  {"log", "ACCESS_TRUST_PROXY"},
+	"TRACE_EXPORTER":          {"trace", "EXPORTER"},
+	"TRACE_FILE":              {"trace", "FILE"},
+	"QR_BRAND_FILE":           {"qr", "BRAND_FILE"},
+	"QR_DEFAULT_BRAND":        {"qr", "DEFAULT_BRAND"},
+	"QR_CACHE_MEMORY_MB":      {"qr", "CACHE_MEMORY_MB"},
+	"QR_CACHE_TIER":           {"qr", "CACHE_TIER"},
+	"QR_CACHE_DIR":            {"qr", "CACHE_DIR"},
+	"QR_CACHE_DISK_MB":        {"qr", "CACHE_DISK_MB"},
+	"QR_CACHE_TTL":            {"qr", "CACHE_TTL"},
+	"LINK_CACHE_TTL":          {"link", "CACHE_TTL"},
+	"LINK_CLICK_FLUSH":        {"link", "CLICK_FLUSH"},
+	"URL_SCHEMES":             {"url", "SCHEMES"},
+	"URL_MAX_LENGTH":          {"url", "MAX_LENGTH"},
+	"URL_TRAILING_SLASH":      {"url", "TRAILING_SLASH"},
+	"URL_ALLOW_FILE":          {"url", "ALLOW_FILE"},
+	"URL_BLOCK_FILE":          {"url", "BLOCK_FILE"},
+	"URL_TRUSTED_FILE":        {"url", "TRUSTED_FILE"},
+	"URL_SHORTENERS":          {"url", "SHORTENERS"},
+	"URL_CHAIN":               {"url", "CHAIN"},
+	"URL_CHAIN_DEPTH":         {"url", "CHAIN_DEPTH"},
+}
+
+// defaultValues are used for keys missing from both environment and file
+var defaultValues = map[string]string{
+	"SHORT_URL_HTTP_ADDR":     ":80",
+	"REDIS_ADDR":              "127.0.0.1:6379",
+	"REDIS_PASSWD":            "",
+	"REDIS_POOL_MAX_IDLE":     "512",
+	"REDIS_POOL_MAX_ACTIVE":   "1024",
+	"REDIS_POOL_IDLE_TIMEOUT": "240",
+	"MYSQL_SWITCH":            "0",
+	"DB_ADDR":                 "127.0.0.1:3306",
+	"DB_USER":                 "root",
+	"DB_PASSWD":               "",
+	"DB_DBNAME":               "short_url",
+	"LOG_FILE_PATH":           "",
+	"LOG_LEVEL":               common.LL_INFO,
+	"LOG_NAME":                "short-url",
+	"LOG_ENCODING":            common.LE_JSON,
+	"LOG_CONSOLE":             common.LC_NONE,
+	"LOG_MAX_SIZE":            "1024",
+	"LOG_MAX_BACKUPS":         "100",
+	"LOG_MAX_AGE":             "30",
+	"LOG_COMPRESS":            "1",
+	"ACCESS_LOG_PATH":         "",
+	"ACCESS_LOG_FORMAT":       common.AF_COMBINED,
+	"ACCESS_LOG_SAMPLE":       "1",
+	"ACCESS_LOG_TRUST_PROXY":  "0",
+	"TRACE_EXPORTER":          common.TE_NONE,
+	"TRACE_FILE":              "./log/trace.json",
+	"QR_BRAND_FILE":           "",
+	"QR_DEFAULT_BRAND":        "",
+	"QR_CACHE_MEMORY_MB":      "32",
+	"QR_CACHE_TIER":           common.QT_NONE,
+	"QR_CACHE_DIR":            "./cache/qr",
+	"QR_CACHE_DISK_MB":        "512",
+	"QR_CACHE_TTL":            "86400",
+	"LINK_CACHE_TTL":          "10",
+	"LINK_CLICK_FLUSH":        "10",
+	"URL_SCHEMES":             "http,https",
+	"URL_MAX_LENGTH":          "255",
+	"URL_TRAILING_SLASH":      common.TS_KEEP,
+	"URL_ALLOW_FILE":          "",
+	"URL_BLOCK_FILE":          "",
+	"URL_TRUSTED_FILE":        "",
+	"URL_SHORTENERS":          common.SHORTENERS,
+	"URL_CHAIN":               common.CH_REJECT,
+	"URL_CHAIN_DEPTH":         "5",
+	"SHORT_URL_HEADER":        common.SHORT_URL_HEADER,
+	"DISABLED_HTML":           "",
+	"BLOCKED_HTML":            "",
+	"TEMPLATE_DIR":            "",
+	"INTERSTITIAL":            common.IM_NONE,
+	"COOKIE_SECRET":           "",
+	"PASSWORD_TTL":            "900",
+	"STATIC_DIR":              common.STATIC_DIR,
+	"STATIC_EMBED":            "0",
+	"ADMIN_TOKEN":             "",
+	"BULK_MAX_ROWS":           "10000",
+	"BULK_BATCH_SIZE":         "100",
+}
+
+// Keys returns every known key, sorted
+func Keys() []string {
+	keys := make([]string, 0, len(defaultValues))
+	for key := range defaultValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
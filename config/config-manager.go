@@ -1,43 +1,244 @@
+// Package config resolves every key in this order, first match wins:
+//
+//  1. environment variable SHORTURL_<KEY>, e.g. SHORTURL_REDIS_ADDR
+//  2. the config file, from --config, else $SHORTURL_CONFIG, else ./conf/short_url_conf.ini
+//  3. built-in defaults
+//
+// Within the file a key is looked up in [<profile>.<section>], [<profile>],
+// [<section>] and the default section, see keySections for the section
+// names; the profile comes from --profile, $SHORTURL_PROFILE or the PROFILE
+// key. Any key may instead be given as <KEY>_FILE naming a file that holds
+// the value, and INCLUDE lists files merged over the main one.
+//
+// A missing default config file is not an error, an explicit one is.
 package config
 
 import (
+	"go.uber.org/zap"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// ConfigChange describes a validated reload handed to subscribers
+type ConfigChange struct {
+	Old         *Config
+	New         *Config
+	Changed     []string
+	NeedRestart []string
+}
+
 type ConfigManager struct {
-	conf ServiceConfig
+	lock        sync.RWMutex
+	conf        *ServiceConfig
+	confFile    string
+	explicit    bool
+	profile     string
+	config      *Config
+	subscribers []func(*ConfigChange)
 }
 
 var m *ConfigManager
 var once sync.Once
 
+// restartKeys are read once at startup, changing them needs a restart
+var restartKeys = map[string]bool{
+	"SHORT_URL_HTTP_ADDR":     true,
+	"STATIC_DIR":              true,
+	"STATIC_EMBED":            true,
+	"REDIS_ADDR":              true,
+	"REDIS_PASSWD":            true,
+	"REDIS_POOL_MAX_IDLE":     true,
+	"REDIS_POOL_MAX_ACTIVE":   true,
+	"REDIS_POOL_IDLE_TIMEOUT": true,
+	"MYSQL_SWITCH":            true,
+	"DB_ADDR":                 true,
+	"DB_USER":                 true,
+	"DB_PASSWD":               true,
+	"DB_DBNAME":               true,
+	"LOG_FILE_PATH":           true,
+	"LOG_NAME":                true,
+	"LOG_ENCODING":            true,
+	"LOG_CONSOLE":             true,
+	"LOG_MAX_SIZE":            true,
+	"LOG_MAX_BACKUPS":         true,
+	"LOG_MAX_AGE":             true,
+	"LOG_COMPRESS":            true,
+	"ACCESS_LOG_PATH":         true,
+	"TRACE_EXPORTER":          true,
+	"TRACE_FILE":              true,
+	"QR_CACHE_MEMORY_MB":      true,
+	"QR_CACHE_TIER":           true,
+	"QR_CACHE_DIR":            true,
+	"QR_CACHE_DISK_MB":        true,
+	"QR_CACHE_TTL":            true,
+}
+
 func GetInstance() *ConfigManager {
 	once.Do(func() {
-		m = &ConfigManager{}
+		m = &ConfigManager{conf: new(ServiceConfig)}
 	})
 	return m
 }
 
+// SetConfigFile overrides the config file path, must be called before InitManager
+func (self *ConfigManager) SetConfigFile(confFile string) {
+	self.confFile = confFile
+}
+
+// SetProfile overrides the profile, must be called before InitManager
+func (self *ConfigManager) SetProfile(profile string) {
+	self.profile = profile
+}
+
 func (self *ConfigManager) InitManager() error {
-	return self.conf.Init("./conf/short_url_conf.ini")
+	confFile, explicit := self.confFile, true
+	if "" == confFile {
+		confFile = os.Getenv(ENV_CONFIG_FILE)
+	}
+	if "" == confFile {
+		confFile, explicit = DEFAULT_CONFIG_FILE, false
+	}
+	if "" == self.profile {
+		self.profile = os.Getenv(ENV_PROFILE)
+	}
+	self.confFile, self.explicit = confFile, explicit
+	conf := new(ServiceConfig)
+	err := conf.Init(confFile, explicit, self.profile)
+	if nil != err {
+		return err
+	}
+	config, err := buildConfig(conf)
+	self.lock.Lock()
+	self.conf, self.config = conf, config
+	self.lock.Unlock()
+	return err
+}
+
+// Config returns the typed config, nil before InitManager loaded a file;
+// after a validation error it holds defaults in place of the bad values.
+// The returned value is never modified, a reload swaps in a new one.
+func (self *ConfigManager) Config() *Config {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.config
+}
+
+// Subscribe registers fn to be called with every validated reload
+func (self *ConfigManager) Subscribe(fn func(*ConfigChange)) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.subscribers = append(self.subscribers, fn)
+}
+
+// Watch polls the config file and its includes and reloads on change
+func (self *ConfigManager) Watch(interval time.Duration) {
+	self.lock.RLock()
+	confName := self.conf.confName
+	self.lock.RUnlock()
+	if "" == confName {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(interval)
+			self.lock.RLock()
+			lastModTime := self.conf.fileLastModTime
+			fileModTime := self.conf.modTime()
+			self.lock.RUnlock()
+			if fileModTime == lastModTime {
+				continue
+			}
+			self.reload()
+		}
+	}()
+}
+
+func (self *ConfigManager) reload() {
+	conf := new(ServiceConfig)
+	err := conf.Init(self.confFile, self.explicit, self.profile)
+	if nil != err {
+		self.skipModTime()
+		logger.Error("reload config file err", zap.String("file", self.confFile), zap.Error(err))
+		return
+	}
+	config, err := buildConfig(conf)
+	if nil != err {
+		self.skipModTime()
+		logger.Error("reload config rejected, keep running config", zap.Error(err))
+		return
+	}
+	self.lock.Lock()
+	old := self.conf
+	change := &ConfigChange{Old: self.config, New: config}
+	for key := range defaultValues {
+		oldValue, _ := old.GetConfig(key)
+		newValue, _ := conf.GetConfig(key)
+		if oldValue == newValue {
+			continue
+		}
+		change.Changed = append(change.Changed, key)
+		if restartKeys[key] {
+			change.NeedRestart = append(change.NeedRestart, key)
+		}
+	}
+	self.conf, self.config = conf, config
+	subscribers := self.subscribers
+	self.lock.Unlock()
+	sort.Strings(change.Changed)
+	sort.Strings(change.NeedRestart)
+	logger.Info("config reloaded", zap.Strings("changed", change.Changed))
+	if 0 != len(change.NeedRestart) {
+		logger.Warn("config keys changed that need a restart", zap.Strings("keys", change.NeedRestart))
+	}
+	if 0 == len(change.Changed) {
+		return
+	}
+	for _, fn := range subscribers {
+		fn(change)
+	}
+}
+
+// skipModTime remembers the modification time of a file failing to load or
+// validate, so it is not re-read every tick but only once changed again
+func (self *ConfigManager) skipModTime() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.conf.fileLastModTime = self.conf.modTime()
+}
+
+func (self *ConfigManager) GetProfile() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.conf.profile
+}
+
+func (self *ConfigManager) GetConfigFile() string {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.conf.confName
 }
 
-func (self ConfigManager) GetConfig(confName string) (string, error) {
-	return self.conf.GetConfig(confName)
+func (self *ConfigManager) GetConfig(confName string) (string, error) {
+	self.lock.RLock()
+	conf := self.conf
+	self.lock.RUnlock()
+	return conf.GetConfig(confName)
 }
 
-func (self ConfigManager) GetInt(confName string) (int, error) {
-	value, err := self.conf.GetConfig(confName)
+func (self *ConfigManager) GetInt(confName string) (int, error) {
+	value, err := self.GetConfig(confName)
 	if nil != err {
 		return 0, err
 	}
 	return strconv.Atoi(value)
 }
 
-func (self ConfigManager) GetConfigArray(configName string) ([]string, error) {
-	str, err := self.conf.GetConfig(configName)
+func (self *ConfigManager) GetConfigArray(configName string) ([]string, error) {
+	str, err := self.GetConfig(configName)
 	if nil != err {
 		return nil, err
 	}
@@ -0,0 +1,364 @@
+package config
+
+import (
+	"github.com/Unknwon/goconfig"
+	"github.com/service-kit/short-url/common"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type RedisConfig struct {
+	Addr            string
+	Passwd          string
+	PoolMaxIdle     int
+	PoolMaxActive   int
+	PoolIdleTimeout int
+}
+
+type MysqlConfig struct {
+	Switch bool
+	Addr   string
+	User   string
+	Passwd string
+	DBName string
+}
+
+type LogConfig struct {
+	FilePath   string
+	Level      string
+	Name       string
+	Encoding   string
+	Console    string
+	MaxSize    int
+	MaxBackups int
+	MaxAge     int
+	Compress   bool
+	Access     AccessLogConfig
+}
+
+type AccessLogConfig struct {
+	Path       string
+	Format     string
+	Sample     int
+	TrustProxy bool
+}
+
+type TraceConfig struct {
+	Exporter string
+	File     string
+}
+
+type QrConfig struct {
+	BrandFile    string
+	DefaultBrand string
+	Cache        QrCacheConfig
+}
+
+type QrCacheConfig struct {
+	MemoryMB int
+	Tier     string
+	Dir      string
+	DiskMB   int
+	TTL      int
+}
+
+type LinkConfig struct {
+	CacheTTL   int
+	ClickFlush int
+}
+
+type UrlConfig struct {
+	Schemes       []string
+	MaxLength     int
+	TrailingSlash string
+	AllowFile     string
+	BlockFile     string
+	TrustedFile   string
+	Shorteners    []string
+	Chain         string
+	ChainDepth    int
+}
+
+// Config is the validated, typed view of every known key
+type Config struct {
+	HttpAddr       string
+	ShortUrlHeader string
+	DisabledHtml   string
+	BlockedHtml    string
+	TemplateDir    string
+	Interstitial   string
+	CookieSecret   string
+	PasswordTTL    int
+	StaticDir      string
+	StaticEmbed    bool
+	AdminToken     string
+	BulkMaxRows    int
+	BulkBatchSize  int
+	Redis          RedisConfig
+	Mysql          MysqlConfig
+	Log            LogConfig
+	Trace          TraceConfig
+	Qr             QrConfig
+	Link           LinkConfig
+	Url            UrlConfig
+}
+
+// ConfigError lists every problem found while validating the config
+type ConfigError struct {
+	Problems []string
+}
+
+func (self *ConfigError) Error() string {
+	return "invalid config: " + strings.Join(self.Problems, "; ")
+}
+
+var schemePattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+type configParser struct {
+	conf     *ServiceConfig
+	problems []string
+}
+
+func (self *configParser) problem(key, msg string) {
+	self.problems = append(self.problems, key+": "+msg)
+}
+
+func (self *configParser) str(key string, required bool) string {
+	value, err := self.conf.GetConfig(key)
+	if nil != err {
+		self.problem(key, err.Error())
+		return ""
+	}
+	value = strings.TrimSpace(value)
+	if required && "" == value {
+		self.problem(key, "is required")
+	}
+	return value
+}
+
+func (self *configParser) int(key string, min, max int) int {
+	value := self.str(key, true)
+	if "" == value {
+		n, _ := strconv.Atoi(defaultValues[key])
+		return n
+	}
+	n, err := strconv.Atoi(value)
+	if nil != err {
+		self.problem(key, "not an integer: "+value)
+		n, _ = strconv.Atoi(defaultValues[key])
+		return n
+	}
+	if n < min || n > max {
+		self.problem(key, "must be between "+strconv.Itoa(min)+" and "+strconv.Itoa(max))
+	}
+	return n
+}
+
+func (self *configParser) addr(key string) string {
+	value := self.str(key, true)
+	if "" == value {
+		return value
+	}
+	_, port, err := net.SplitHostPort(value)
+	if nil != err {
+		self.problem(key, "not a host:port address: "+value)
+		return value
+	}
+	n, err := strconv.Atoi(port)
+	if nil != err || n < 0 || n > 65535 {
+		self.problem(key, "invalid port: "+port)
+	}
+	return value
+}
+
+func (self *configParser) oneOf(key string, values ...string) string {
+	value := self.str(key, false)
+	for _, v := range values {
+		if v == value {
+			return value
+		}
+	}
+	self.problem(key, "must be one of "+strings.Join(values, ", "))
+	return value
+}
+
+// switchOn reads a 0/1 switch
+func (self *configParser) switchOn(key string) bool {
+	return strconv.Itoa(common.SWITHC_ON) == self.oneOf(key, strconv.Itoa(common.SWITHC_OFF), strconv.Itoa(common.SWITHC_ON))
+}
+
+// sectionKeys returns the keys allowed in section, nil for the default
+// section and profiles, which take the full key names
+func sectionKeys(section string) map[string]bool {
+	if i := strings.LastIndex(section, "."); i >= 0 {
+		section = section[i+1:]
+	}
+	var keys map[string]bool
+	for _, spec := range keySections {
+		if spec[0] != section {
+			continue
+		}
+		if nil == keys {
+			keys = make(map[string]bool)
+		}
+		keys[spec[1]] = true
+	}
+	return keys
+}
+
+func isKnownKey(keys map[string]bool, key string) bool {
+	// keys like QR_BRAND_FILE end in the secret suffix themselves
+	for _, name := range []string{key, strings.TrimSuffix(key, SECRET_FILE_SUFFIX)} {
+		if nil != keys && keys[name] {
+			return true
+		}
+		if _, ok := defaultValues[name]; nil == keys && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownKeys reports file keys and SHORTURL_ variables no one reads,
+// which are almost always typos
+func (self *configParser) unknownKeys() {
+	if nil != self.conf.conf {
+		for _, section := range self.conf.conf.GetSectionList() {
+			keys := sectionKeys(section)
+			for _, key := range self.conf.conf.GetKeyList(section) {
+				if goconfig.DEFAULT_SECTION == section && (PROFILE_KEY == key || INCLUDE_KEY == key) {
+					continue
+				}
+				if !isKnownKey(keys, key) {
+					self.problem(key, "unknown key in ["+section+"] of "+self.conf.confName)
+				}
+			}
+		}
+	}
+	var envs []string
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if !strings.HasPrefix(name, ENV_PREFIX) || ENV_CONFIG_FILE == name || ENV_PROFILE == name {
+			continue
+		}
+		if !isKnownKey(nil, strings.TrimPrefix(name, ENV_PREFIX)) {
+			envs = append(envs, name)
+		}
+	}
+	sort.Strings(envs)
+	for _, name := range envs {
+		self.problem(name, "unknown environment override")
+	}
+}
+
+// buildConfig parses and validates conf, the returned Config is always
+// usable with defaults in place of bad values
+func buildConfig(conf *ServiceConfig) (*Config, error) {
+	p := &configParser{conf: conf}
+	c := new(Config)
+	c.HttpAddr = p.addr("SHORT_URL_HTTP_ADDR")
+	c.ShortUrlHeader = p.str("SHORT_URL_HEADER", true)
+	if u, err := url.Parse(c.ShortUrlHeader); nil != err || ("http" != u.Scheme && "https" != u.Scheme) || "" == u.Host || !strings.HasSuffix(c.ShortUrlHeader, "/") {
+		p.problem("SHORT_URL_HEADER", "must be an http(s) url ending with /")
+		c.ShortUrlHeader = common.SHORT_URL_HEADER
+	}
+	c.DisabledHtml = p.str("DISABLED_HTML", false)
+	c.BlockedHtml = p.str("BLOCKED_HTML", false)
+	c.TemplateDir = p.str("TEMPLATE_DIR", false)
+	c.Interstitial = p.oneOf("INTERSTITIAL", common.IM_NONE, common.IM_UNTRUSTED, common.IM_ALL)
+	c.CookieSecret = p.str("COOKIE_SECRET", false)
+	if "" != c.CookieSecret && len(c.CookieSecret) < 16 {
+		p.problem("COOKIE_SECRET", "must be at least 16 characters")
+	}
+	c.PasswordTTL = p.int("PASSWORD_TTL", 10, 30*86400)
+	c.StaticEmbed = p.switchOn("STATIC_EMBED")
+	c.StaticDir = p.str("STATIC_DIR", !c.StaticEmbed)
+	c.AdminToken = p.str("ADMIN_TOKEN", false)
+	c.BulkMaxRows = p.int("BULK_MAX_ROWS", 1, 1000000)
+	c.BulkBatchSize = p.int("BULK_BATCH_SIZE", 1, 10000)
+
+	c.Redis.Addr = p.addr("REDIS_ADDR")
+	c.Redis.Passwd = p.str("REDIS_PASSWD", false)
+	c.Redis.PoolMaxIdle = p.int("REDIS_POOL_MAX_IDLE", 0, 100000)
+	c.Redis.PoolMaxActive = p.int("REDIS_POOL_MAX_ACTIVE", 0, 100000)
+	c.Redis.PoolIdleTimeout = p.int("REDIS_POOL_IDLE_TIMEOUT", 0, 86400)
+	if 0 != c.Redis.PoolMaxActive && c.Redis.PoolMaxIdle > c.Redis.PoolMaxActive {
+		p.problem("REDIS_POOL_MAX_IDLE", "must not exceed REDIS_POOL_MAX_ACTIVE")
+	}
+
+	c.Mysql.Switch = p.switchOn("MYSQL_SWITCH")
+	if c.Mysql.Switch {
+		c.Mysql.Addr = p.addr("DB_ADDR")
+		c.Mysql.User = p.str("DB_USER", true)
+		c.Mysql.DBName = p.str("DB_DBNAME", true)
+	} else {
+		c.Mysql.Addr = p.str("DB_ADDR", false)
+		c.Mysql.User = p.str("DB_USER", false)
+		c.Mysql.DBName = p.str("DB_DBNAME", false)
+	}
+	c.Mysql.Passwd = p.str("DB_PASSWD", false)
+
+	c.Log.FilePath = p.str("LOG_FILE_PATH", false)
+	c.Log.Level = p.oneOf("LOG_LEVEL", common.LL_DEBUG, common.LL_INFO, common.LL_ERROR)
+	c.Log.Name = p.str("LOG_NAME", true)
+	c.Log.Encoding = p.oneOf("LOG_ENCODING", common.LE_JSON, common.LE_CONSOLE)
+	c.Log.Console = p.oneOf("LOG_CONSOLE", common.LC_NONE, common.LC_STDOUT, common.LC_STDERR)
+	c.Log.MaxSize = p.int("LOG_MAX_SIZE", 1, 1024*1024)
+	c.Log.MaxBackups = p.int("LOG_MAX_BACKUPS", 0, 100000)
+	c.Log.MaxAge = p.int("LOG_MAX_AGE", 0, 36500)
+	c.Log.Compress = p.switchOn("LOG_COMPRESS")
+	c.Log.Access.Path = p.str("ACCESS_LOG_PATH", false)
+	c.Log.Access.Format = p.oneOf("ACCESS_LOG_FORMAT", common.AF_COMBINED, common.AF_JSON)
+	c.Log.Access.Sample = p.int("ACCESS_LOG_SAMPLE", 1, 1000000)
+	c.Log.Access.TrustProxy = p.switchOn("ACCESS_LOG_TRUST_PROXY")
+
+	c.Trace.Exporter = p.oneOf("TRACE_EXPORTER", common.TE_NONE, common.TE_STDOUT, common.TE_FILE)
+	c.Trace.File = p.str("TRACE_FILE", common.TE_FILE == c.Trace.Exporter)
+	c.Qr.BrandFile = p.str("QR_BRAND_FILE", false)
+	c.Qr.DefaultBrand = p.str("QR_DEFAULT_BRAND", false)
+	if "" != c.Qr.DefaultBrand && "" == c.Qr.BrandFile {
+		p.problem("QR_DEFAULT_BRAND", "needs QR_BRAND_FILE")
+	}
+	c.Qr.Cache.MemoryMB = p.int("QR_CACHE_MEMORY_MB", 0, 64*1024)
+	c.Qr.Cache.Tier = p.oneOf("QR_CACHE_TIER", common.QT_NONE, common.QT_DISK, common.QT_REDIS)
+	c.Qr.Cache.Dir = p.str("QR_CACHE_DIR", common.QT_DISK == c.Qr.Cache.Tier)
+	c.Qr.Cache.DiskMB = p.int("QR_CACHE_DISK_MB", 1, 1024*1024)
+	c.Qr.Cache.TTL = p.int("QR_CACHE_TTL", 1, 365*86400)
+
+	c.Link.CacheTTL = p.int("LINK_CACHE_TTL", 1, 86400)
+	c.Link.ClickFlush = p.int("LINK_CLICK_FLUSH", 1, 3600)
+
+	for _, scheme := range strings.Split(p.str("URL_SCHEMES", true), ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if !schemePattern.MatchString(scheme) {
+			p.problem("URL_SCHEMES", "invalid scheme "+scheme)
+			continue
+		}
+		c.Url.Schemes = append(c.Url.Schemes, scheme)
+	}
+	// longer urls do not fit the original_url column
+	c.Url.MaxLength = p.int("URL_MAX_LENGTH", 16, common.ORIGINAL_URL_MAX_LENGTH)
+	c.Url.TrailingSlash = p.oneOf("URL_TRAILING_SLASH", common.TS_KEEP, common.TS_ADD, common.TS_STRIP)
+	c.Url.AllowFile = p.str("URL_ALLOW_FILE", false)
+	c.Url.BlockFile = p.str("URL_BLOCK_FILE", false)
+	c.Url.TrustedFile = p.str("URL_TRUSTED_FILE", false)
+	for _, domain := range strings.Split(p.str("URL_SHORTENERS", false), ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if "" != domain {
+			c.Url.Shorteners = append(c.Url.Shorteners, domain)
+		}
+	}
+	c.Url.Chain = p.oneOf("URL_CHAIN", common.CH_REJECT, common.CH_FLATTEN)
+	c.Url.ChainDepth = p.int("URL_CHAIN_DEPTH", 1, 32)
+
+	p.unknownKeys()
+	if 0 != len(p.problems) {
+		return c, &ConfigError{Problems: p.problems}
+	}
+	return c, nil
+}
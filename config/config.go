@@ -5,74 +5,163 @@ import (
 	"github.com/Unknwon/goconfig"
 	"github.com/service-kit/short-url/util"
 	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
 )
 
-var logger *zap.Logger
+var logger = zap.NewNop()
+
+// SetLogger sets the logger used for reload reports, config is loaded
+// before log so it starts with a no-op logger
+func SetLogger(l *zap.Logger) {
+	logger = l
+}
 
 type ServiceConfig struct {
 	conf            *goconfig.ConfigFile
 	confName        string
+	fileNames       []string
+	profile         string
 	isLoadSucc      bool
 	fileLastModTime int64
-	lastCheckTime   int64
 }
 
-func (self *ServiceConfig) Init(confFile string) error {
+// Init loads confFile and the files it includes, when it does not exist and
+// was not asked for explicitly only environment and defaults are used.
+// profile may be empty, the PROFILE key of the file is used then.
+func (self *ServiceConfig) Init(confFile string, explicit bool, profile string) error {
 	self.confName = confFile
 	var err error = nil
+	if _, statErr := os.Stat(confFile); os.IsNotExist(statErr) && !explicit {
+		self.confName = ""
+		self.conf, err = goconfig.LoadFromReader(strings.NewReader(""))
+		if nil != err {
+			return err
+		}
+		self.profile = profile
+		self.isLoadSucc = true
+		return nil
+	}
 	self.conf, err = goconfig.LoadConfigFile(confFile)
 	if nil != err {
 		return err
 	}
+	self.fileNames = []string{confFile}
+	if includes, err := self.conf.GetValue("", INCLUDE_KEY); nil == err && "" != includes {
+		for _, include := range strings.Split(includes, ",") {
+			include = strings.TrimSpace(include)
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(confFile), include)
+			}
+			self.fileNames = append(self.fileNames, include)
+		}
+		self.conf, err = goconfig.LoadConfigFile(confFile, self.fileNames[1:]...)
+		if nil != err {
+			return err
+		}
+	}
+	self.fileLastModTime = self.modTime()
+	if "" == profile {
+		profile, _ = self.conf.GetValue("", PROFILE_KEY)
+	}
+	self.profile = profile
 	self.isLoadSucc = true
-	self.fileLastModTime, _ = util.GetFileModTime(self.confName)
-	self.CheckConfigFile()
 	return err
 }
 
-func (self ServiceConfig) GetConfig(confName string) (string, error) {
-	if !self.isLoadSucc {
-		return "", errors.New("GetConfig fail! Is not load success!!!")
+// modTime is the latest modification time of all loaded files
+func (self *ServiceConfig) modTime() int64 {
+	var latest int64
+	for _, name := range self.fileNames {
+		modTime, _ := util.GetFileModTime(name)
+		if modTime > latest {
+			latest = modTime
+		}
+	}
+	return latest
+}
+
+// lookup returns key of section, or the content of the file named by
+// key + SECRET_FILE_SUFFIX
+func (self *ServiceConfig) lookup(section, key string) (string, bool, error) {
+	hasSecret := false
+	for _, k := range self.conf.GetKeyList(section) {
+		if k == key {
+			value, err := self.conf.GetValue(section, key)
+			return value, true, err
+		}
+		hasSecret = hasSecret || k == key+SECRET_FILE_SUFFIX
 	}
-	return self.conf.GetValue("", confName)
+	if !hasSecret {
+		return "", false, nil
+	}
+	path, err := self.conf.GetValue(section, key+SECRET_FILE_SUFFIX)
+	if nil != err {
+		return "", true, err
+	}
+	value, err := readSecret(path)
+	return value, true, err
 }
 
-func (self ServiceConfig) GetInt(confName string) (int, error) {
+func readSecret(path string) (string, error) {
+	value, err := ioutil.ReadFile(path)
+	if nil != err {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+// GetConfig resolves confName, first match wins:
+// environment, [<profile>.<section>], [<profile>], [<section>], default section, defaults
+func (self *ServiceConfig) GetConfig(confName string) (string, error) {
+	if value, ok := os.LookupEnv(ENV_PREFIX + confName); ok {
+		return value, nil
+	}
+	if path, ok := os.LookupEnv(ENV_PREFIX + confName + SECRET_FILE_SUFFIX); ok {
+		return readSecret(path)
+	}
 	if !self.isLoadSucc {
-		return 0, errors.New("GetConfig fail! Is not load success!!!")
+		return "", errors.New("GetConfig fail! Is not load success!!!")
+	}
+	var places [][2]string
+	spec, sectioned := keySections[confName]
+	if "" != self.profile {
+		if sectioned {
+			places = append(places, [2]string{self.profile + "." + spec[0], spec[1]})
+		}
+		places = append(places, [2]string{self.profile, confName})
+	}
+	if sectioned {
+		places = append(places, spec)
+	}
+	places = append(places, [2]string{"", confName})
+	for _, place := range places {
+		value, ok, err := self.lookup(place[0], place[1])
+		if ok {
+			return value, err
+		}
 	}
-	val, err := self.conf.GetValue("", confName)
+	if value, ok := defaultValues[confName]; ok {
+		return value, nil
+	}
+	return "", errors.New("config " + confName + " not found")
+}
+
+func (self *ServiceConfig) GetInt(confName string) (int, error) {
+	val, err := self.GetConfig(confName)
 	if nil != err {
 		return 0, err
 	}
 	return strconv.Atoi(val)
 }
 
-func (self ServiceConfig) GetBool(confName string) (bool, error) {
-	if !self.isLoadSucc {
-		return false, errors.New("GetConfig fail! Is not load success!!!")
-	}
-	val, err := self.conf.GetValue("", confName)
+func (self *ServiceConfig) GetBool(confName string) (bool, error) {
+	val, err := self.GetConfig(confName)
 	if nil != err {
 		return false, err
 	}
 	return strconv.ParseBool(val)
 }
-
-func (self *ServiceConfig) CheckConfigFile() {
-	go func() {
-		for {
-			fileModTime, err := util.GetFileModTime(self.confName)
-			if nil != err {
-				continue
-			}
-			if fileModTime != self.fileLastModTime {
-				self.conf.Reload()
-				self.fileLastModTime = fileModTime
-			}
-			time.Sleep(time.Second)
-		}
-	}()
-}
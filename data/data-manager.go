@@ -1,22 +1,52 @@
 package data
 
 import (
+	"context"
+	"errors"
 	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
 	"github.com/service-kit/short-url/log"
 	"github.com/service-kit/short-url/storage"
+	"github.com/service-kit/short-url/trace"
+	"github.com/service-kit/short-url/util"
 	"go.uber.org/zap"
+	"regexp"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type DataManager struct {
 	cacheLock      sync.RWMutex
-	shortUrlMap    map[string]string
+	shortUrlMap    map[string]cacheEntry
 	originalUrlMap map[string]string
+	subscribers    []func(context.Context, string)
+	urlChecks      []UrlCheck
+	clickLock      sync.Mutex
+	// clicks counted since the last flush to redis, and those in redis
+	// but not yet in mysql
+	clicks   map[string]int64
+	dbClicks map[string]int64
 }
 
+// cacheEntry is a link as read from storage at loadTime, it is re-read once
+// older than LINK_CACHE_TTL as other processes, e.g. cli commands, change
+// links behind our back
+type cacheEntry struct {
+	info     common.ShortUrlInfo
+	loadTime int64
+}
+
+// UrlCheck returns the form an original url is stored in, or why it can not
+// be shortened
+type UrlCheck func(ctx context.Context, original_url string) (string, error)
+
+// aliasPattern keeps codes clear of the routes and of the preview suffix,
+// which all need characters besides these
+var aliasPattern = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+
 var m *DataManager
 var once sync.Once
-var logger *zap.Logger
 
 func GetInstance() *DataManager {
 	once.Do(func() {
@@ -26,73 +56,496 @@ func GetInstance() *DataManager {
 }
 
 func (self *DataManager) InitManager() (err error) {
-	self.shortUrlMap = make(map[string]string)
+	self.shortUrlMap = make(map[string]cacheEntry)
 	self.originalUrlMap = make(map[string]string)
-	logger = log.GetInstance().GetLogger()
-	if nil != err {
-		return
-	}
-	self.loadShortUrl()
+	self.clicks = make(map[string]int64)
+	self.dbClicks = make(map[string]int64)
 	return
 }
 
-func (self *DataManager) loadShortUrl() error {
-	urls, err := storage.GetInstance().LoadAllShortUrlData()
+// Preload fills the cache with every link, only worth it for the http
+// server; cli commands read the few links they touch from storage
+func (self *DataManager) Preload(ctx context.Context) error {
+	urls, err := storage.GetInstance().LoadAllShortUrlData(ctx)
 	if nil != err {
 		return err
 	}
-	for _, short_url := range urls {
-		self.shortUrlMap[short_url.ShortUrl] = short_url.OriginalUrl
-		self.originalUrlMap[short_url.OriginalUrl] = short_url.ShortUrl
+	for i := range urls {
+		self.addToCache(&urls[i])
 	}
 	return err
 }
 
-func (self *DataManager) GetOriginalUrl(short_url string) (string, error) {
+func startSpan(ctx context.Context, name string) (context.Context, *trace.Span) {
+	return trace.Start(ctx, "data."+name)
+}
+
+func endSpan(span *trace.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+func cacheTTL() int64 {
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return 0
+	}
+	return int64(conf.Link.CacheTTL)
+}
+
+// GetShortUrlInfo returns the link from cache while fresh, else from storage
+func (self *DataManager) GetShortUrlInfo(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	ctx, span := startSpan(ctx, "GetShortUrlInfo")
+	defer span.End()
+	self.cacheLock.RLock()
+	entry, ok := self.shortUrlMap[short_url]
+	self.cacheLock.RUnlock()
+	if ok && util.GetCurrentSeconds()-entry.loadTime < cacheTTL() {
+		span.SetAttribute("cache.hit", "true")
+		return &entry.info, nil
+	}
+	span.SetAttribute("cache.hit", "false")
+	short_url_info, err := self.reload(ctx, short_url)
+	span.SetError(err)
+	return short_url_info, err
+}
+
+// GetLiveShortUrlInfo reads the link from storage, so a link deleted or
+// disabled by another process stops redirecting at once; the cached copy
+// is only used while storage is unreachable
+func (self *DataManager) GetLiveShortUrlInfo(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	ctx, span := startSpan(ctx, "GetLiveShortUrlInfo")
+	defer span.End()
+	short_url_info, err := self.reload(ctx, short_url)
+	span.SetError(err)
+	return short_url_info, err
+}
+
+// reload refreshes the cache entry of short_url from storage
+func (self *DataManager) reload(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	short_url_info, err := storage.GetInstance().GetShortUrlInfo(ctx, short_url)
+	if nil == err {
+		self.addToCache(short_url_info)
+		return short_url_info, nil
+	}
 	self.cacheLock.RLock()
-	original_url := self.originalUrlMap[short_url]
-	if "" != original_url {
-		self.cacheLock.RUnlock()
-		return original_url, nil
+	entry, ok := self.shortUrlMap[short_url]
+	self.cacheLock.RUnlock()
+	if !ok {
+		return nil, err
+	}
+	if storage.IsNotExist(err) {
+		// purged elsewhere
+		self.removeFromCache(&entry.info)
+		return nil, err
 	}
-	original_url, err := storage.GetInstance().GetOriginalUrl(short_url)
+	log.FromContext(ctx).Warn("reload short url err, use cached", zap.String("short url", short_url), zap.Error(err))
+	return &entry.info, nil
+}
+
+func (self *DataManager) GetOriginalUrl(ctx context.Context, short_url string) (string, error) {
+	short_url_info, err := self.GetShortUrlInfo(ctx, short_url)
 	if nil != err {
-		return original_url, err
+		return "", err
 	}
-	self.addToCache(original_url, short_url)
-	return original_url, err
+	return short_url_info.OriginalUrl, nil
 }
 
 func (self *DataManager) GetShortUrl(original_url string) (string, error) {
 	self.cacheLock.RLock()
+	defer self.cacheLock.RUnlock()
 	short_url := self.originalUrlMap[original_url]
-	if "" != short_url {
-		self.cacheLock.RUnlock()
-		return short_url, nil
+	if "" == short_url {
+		return "", errors.New(common.ERROR_NOT_EXIST)
 	}
-	original_url, err := storage.GetInstance().GetOriginalUrl(short_url)
-	if nil != err {
-		return short_url, err
+	return short_url, nil
+}
+
+func (self *DataManager) addToCache(short_url_info *common.ShortUrlInfo) {
+	self.cacheLock.Lock()
+	defer self.cacheLock.Unlock()
+	// a replaced link may point elsewhere now
+	if old, ok := self.shortUrlMap[short_url_info.ShortUrl]; ok && old.info.OriginalUrl != short_url_info.OriginalUrl &&
+		self.originalUrlMap[old.info.OriginalUrl] == short_url_info.ShortUrl {
+		delete(self.originalUrlMap, old.info.OriginalUrl)
+	}
+	self.shortUrlMap[short_url_info.ShortUrl] = cacheEntry{info: *short_url_info, loadTime: util.GetCurrentSeconds()}
+	if common.LS_ACTIVE == short_url_info.Status && short_url_info.Plain() {
+		self.originalUrlMap[short_url_info.OriginalUrl] = short_url_info.ShortUrl
+	} else if self.originalUrlMap[short_url_info.OriginalUrl] == short_url_info.ShortUrl {
+		delete(self.originalUrlMap, short_url_info.OriginalUrl)
+	}
+}
+
+// Subscribe registers fn to be called with the code of every link changed
+// or removed after creation, e.g. to drop derived caches
+func (self *DataManager) Subscribe(fn func(ctx context.Context, short_url string)) {
+	self.cacheLock.Lock()
+	defer self.cacheLock.Unlock()
+	self.subscribers = append(self.subscribers, fn)
+}
+
+// AddUrlCheck registers check to run on the original url of every new link
+func (self *DataManager) AddUrlCheck(check UrlCheck) {
+	self.cacheLock.Lock()
+	defer self.cacheLock.Unlock()
+	self.urlChecks = append(self.urlChecks, check)
+}
+
+// CheckOriginalUrl runs the url checks of new links on short_url_info,
+// normalizing its original url, for links stored by other means like import
+func (self *DataManager) CheckOriginalUrl(ctx context.Context, short_url_info *common.ShortUrlInfo) error {
+	return self.checkOriginalUrl(ctx, short_url_info)
+}
+
+func (self *DataManager) checkOriginalUrl(ctx context.Context, short_url_info *common.ShortUrlInfo) error {
+	self.cacheLock.RLock()
+	checks := self.urlChecks
+	self.cacheLock.RUnlock()
+	for _, check := range checks {
+		original_url, err := check(ctx, short_url_info.OriginalUrl)
+		if nil != err {
+			return err
+		}
+		short_url_info.OriginalUrl = original_url
+	}
+	return nil
+}
+
+func (self *DataManager) notifyChange(ctx context.Context, short_url string) {
+	self.cacheLock.RLock()
+	subscribers := self.subscribers
+	self.cacheLock.RUnlock()
+	for _, fn := range subscribers {
+		fn(ctx, short_url)
 	}
-	self.addToCache(original_url, short_url)
-	return short_url, err
 }
 
-func (self *DataManager) addToCache(original_url, short_url string) {
+func (self *DataManager) removeFromCache(short_url_info *common.ShortUrlInfo) {
 	self.cacheLock.Lock()
 	defer self.cacheLock.Unlock()
-	self.originalUrlMap[original_url] = short_url
-	self.shortUrlMap[short_url] = original_url
+	delete(self.shortUrlMap, short_url_info.ShortUrl)
+	if self.originalUrlMap[short_url_info.OriginalUrl] == short_url_info.ShortUrl {
+		delete(self.originalUrlMap, short_url_info.OriginalUrl)
+	}
+}
+
+// checkShortUrl picks the code for short_url_info and reports whether the
+// same link is already registered under it; a generated code falls back to
+// the other candidates when taken by another, deleted, disabled or expired
+// link. reserved holds the codes picked earlier in the same batch
+func (self *DataManager) checkShortUrl(ctx context.Context, short_url_info *common.ShortUrlInfo, reserved map[string]*common.ShortUrlInfo) (bool, error) {
+	if "" != short_url_info.ShortUrl {
+		if err := CheckAlias(short_url_info.ShortUrl); nil != err {
+			return false, err
+		}
+	}
+	now := util.GetCurrentSeconds()
+	candidates := []string{short_url_info.ShortUrl}
+	if "" == short_url_info.ShortUrl {
+		if short_url, err := self.GetShortUrl(short_url_info.OriginalUrl); nil == err {
+			existing, err := self.GetShortUrlInfo(ctx, short_url)
+			if nil == err && isLive(existing, now) && sameLink(existing, short_url_info) {
+				short_url_info.ShortUrl = short_url
+				return true, nil
+			}
+		}
+		// the salted hash gives protected links codes of their own
+		codes := util.BuildShortUrls(short_url_info.OriginalUrl + short_url_info.PasswordHash)
+		candidates = codes[:]
+	}
+	for _, candidate := range candidates {
+		if other, ok := reserved[candidate]; ok {
+			if sameLink(other, short_url_info) {
+				short_url_info.ShortUrl = candidate
+				return true, nil
+			}
+			continue
+		}
+		existing, err := self.GetShortUrlInfo(ctx, candidate)
+		if storage.IsNotExist(err) || (nil == err && "" == existing.OriginalUrl) {
+			short_url_info.ShortUrl = candidate
+			return false, nil
+		}
+		if nil != err {
+			return false, err
+		}
+		if isLive(existing, now) && sameLink(existing, short_url_info) {
+			short_url_info.ShortUrl = candidate
+			return true, nil
+		}
+	}
+	if 1 == len(candidates) {
+		return false, errors.New(common.ERROR_EXIST)
+	}
+	return false, errors.New(common.ERROR_CAN_NOT_REGISTER)
+}
+
+// CheckAlias checks a code asked for instead of a generated one
+func CheckAlias(short_url string) error {
+	if !aliasPattern.MatchString(short_url) {
+		return errors.New("short_url may only contain letters, digits, '_' and '-', at most 64")
+	}
+	return nil
 }
 
-func (self *DataManager) AddNewShortUrl(original_url, short_url string) error {
-	self.addToCache(original_url, short_url)
-	short_url_info := new(common.ShortUrlInfo)
-	short_url_info.ShortUrl = short_url
-	short_url_info.OriginalUrl = original_url
-	exist, err := storage.GetInstance().StorageShortUrlInfo(short_url_info)
+// isLive reports whether the link still redirects, dead links are never
+// handed out again
+func isLive(short_url_info *common.ShortUrlInfo, now int64) bool {
+	return common.LS_ACTIVE == short_url_info.Status && !short_url_info.IsExpired(now)
+}
+
+// sameLink reports whether b asks for exactly what a is, so a can be handed
+// out for it; links with a password are never shared
+func sameLink(a, b *common.ShortUrlInfo) bool {
+	return a.OriginalUrl == b.OriginalUrl && a.Interstitial == b.Interstitial && a.Tags == b.Tags && a.ExpireTime == b.ExpireTime &&
+		"" == a.PasswordHash && "" == b.PasswordHash
+}
+
+// CreateShortUrl registers short_url_info, an empty ShortUrl is generated
+func (self *DataManager) CreateShortUrl(ctx context.Context, short_url_info *common.ShortUrlInfo) (err error) {
+	ctx, span := startSpan(ctx, "CreateShortUrl")
+	defer func() { endSpan(span, err) }()
+	err = self.checkOriginalUrl(ctx, short_url_info)
+	if nil != err {
+		return err
+	}
+	exist, err := self.checkShortUrl(ctx, short_url_info, nil)
+	if nil != err || exist {
+		return err
+	}
+	exist, err = storage.GetInstance().StorageShortUrlInfo(ctx, short_url_info)
 	if nil != err && !exist {
 		return err
 	}
+	self.addToCache(short_url_info)
+	return nil
+}
+
+// CreateShortUrls registers short_urls writing batchSize rows per storage
+// transaction, the returned errors line up with short_urls. Rows asking for
+// the same link share its code and its outcome
+func (self *DataManager) CreateShortUrls(ctx context.Context, short_urls []*common.ShortUrlInfo, batchSize int) []error {
+	ctx, span := startSpan(ctx, "CreateShortUrls")
+	defer span.End()
+	span.SetAttribute("batch.rows", strconv.Itoa(len(short_urls)))
+	errs := make([]error, len(short_urls))
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	reserved := make(map[string]*common.ShortUrlInfo)
+	seen := make(map[string]int)
+	var pending, dups []int
+	for i, short_url_info := range short_urls {
+		err := self.checkOriginalUrl(ctx, short_url_info)
+		if nil != err {
+			errs[i] = err
+			continue
+		}
+		exist, err := self.checkShortUrl(ctx, short_url_info, reserved)
+		if nil != err {
+			errs[i] = err
+			continue
+		}
+		if _, ok := seen[short_url_info.ShortUrl]; ok && exist {
+			dups = append(dups, i)
+			continue
+		}
+		if exist {
+			continue
+		}
+		reserved[short_url_info.ShortUrl] = short_url_info
+		seen[short_url_info.ShortUrl] = i
+		pending = append(pending, i)
+	}
+	for start := 0; start < len(pending); start += batchSize {
+		end := start + batchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := make([]*common.ShortUrlInfo, 0, end-start)
+		for _, i := range pending[start:end] {
+			batch = append(batch, short_urls[i])
+		}
+		batchErrs := storage.GetInstance().StorageShortUrlInfos(ctx, batch)
+		for k, i := range pending[start:end] {
+			errs[i] = batchErrs[k]
+			if nil == batchErrs[k] {
+				self.addToCache(short_urls[i])
+			}
+		}
+	}
+	for _, i := range dups {
+		errs[i] = errs[seen[short_urls[i].ShortUrl]]
+	}
+	return errs
+}
+
+func (self *DataManager) DeleteShortUrl(ctx context.Context, short_url string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteShortUrl")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().DeleteShortUrl(ctx, short_url)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	log.FromContext(ctx).Info("delete short url", zap.String("short url", short_url))
+	self.notifyChange(ctx, short_url)
+	return nil
+}
+
+func (self *DataManager) DisableShortUrl(ctx context.Context, short_url, reason string) (err error) {
+	ctx, span := startSpan(ctx, "DisableShortUrl")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().DisableShortUrl(ctx, short_url, reason)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	log.FromContext(ctx).Info("disable short url", zap.String("short url", short_url), zap.String("reason", reason))
+	self.notifyChange(ctx, short_url)
+	return nil
+}
+
+func (self *DataManager) RestoreShortUrl(ctx context.Context, short_url string) (err error) {
+	ctx, span := startSpan(ctx, "RestoreShortUrl")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().RestoreShortUrl(ctx, short_url)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	log.FromContext(ctx).Info("restore short url", zap.String("short url", short_url))
+	self.notifyChange(ctx, short_url)
+	return nil
+}
+
+func (self *DataManager) SetInterstitial(ctx context.Context, short_url string, on bool) (err error) {
+	ctx, span := startSpan(ctx, "SetInterstitial")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().SetInterstitial(ctx, short_url, on)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	log.FromContext(ctx).Info("set short url interstitial", zap.String("short url", short_url), zap.Bool("on", on))
+	self.notifyChange(ctx, short_url)
+	return nil
+}
+
+func (self *DataManager) SetPasswordHash(ctx context.Context, short_url, password_hash string) (err error) {
+	ctx, span := startSpan(ctx, "SetPasswordHash")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().SetPasswordHash(ctx, short_url, password_hash)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	log.FromContext(ctx).Info("set short url password", zap.String("short url", short_url), zap.Bool("protected", "" != password_hash))
+	self.notifyChange(ctx, short_url)
+	return nil
+}
+
+func (self *DataManager) PurgeShortUrl(ctx context.Context, short_url string) (err error) {
+	ctx, span := startSpan(ctx, "PurgeShortUrl")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err := storage.GetInstance().PurgeShortUrl(ctx, short_url)
+	if nil != err {
+		return err
+	}
+	self.removeFromCache(short_url_info)
+	// counting on would bring its counter back
+	self.clickLock.Lock()
+	delete(self.clicks, short_url)
+	delete(self.dbClicks, short_url)
+	self.clickLock.Unlock()
+	log.FromContext(ctx).Info("purge short url", zap.String("short url", short_url))
+	self.notifyChange(ctx, short_url)
 	return nil
 }
+
+// ReplaceShortUrl stores short_url_info as is, used by import after
+// CheckOriginalUrl
+func (self *DataManager) ReplaceShortUrl(ctx context.Context, short_url_info *common.ShortUrlInfo) (err error) {
+	ctx, span := startSpan(ctx, "ReplaceShortUrl")
+	defer func() { endSpan(span, err) }()
+	err = storage.GetInstance().ReplaceShortUrlInfo(ctx, short_url_info)
+	if nil != err {
+		return err
+	}
+	self.addToCache(short_url_info)
+	self.notifyChange(ctx, short_url_info.ShortUrl)
+	return nil
+}
+
+// CountClick counts a redirect of short_url in memory, FlushClicks writes
+// the counts out
+func (self *DataManager) CountClick(short_url string) {
+	self.clickLock.Lock()
+	self.clicks[short_url]++
+	self.clickLock.Unlock()
+}
+
+func clickFlushInterval() time.Duration {
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return time.Minute
+	}
+	return time.Duration(conf.Link.ClickFlush) * time.Second
+}
+
+// FlushClicks starts the one worker adding the counted clicks to redis every
+// interval and to mysql every LINK_CLICK_FLUSH seconds in one transaction;
+// clicks not flushed yet are lost when the process dies
+func (self *DataManager) FlushClicks(interval time.Duration) {
+	go func() {
+		lastDBFlush := time.Now()
+		for {
+			time.Sleep(interval)
+			toDB := time.Since(lastDBFlush) >= clickFlushInterval()
+			if toDB {
+				lastDBFlush = time.Now()
+			}
+			self.flushClicks(context.Background(), toDB)
+		}
+	}()
+}
+
+func (self *DataManager) flushClicks(ctx context.Context, toDB bool) {
+	self.clickLock.Lock()
+	clicks := self.clicks
+	self.clicks = make(map[string]int64)
+	self.clickLock.Unlock()
+	for short_url, n := range clicks {
+		err := storage.GetInstance().IncrClicks(ctx, short_url, n)
+		if nil != err {
+			log.FromContext(ctx).Error("incr short url clicks err", zap.String("short url", short_url), zap.Int64("clicks", n), zap.Error(err))
+		}
+	}
+	self.clickLock.Lock()
+	for short_url, n := range clicks {
+		self.dbClicks[short_url] += n
+	}
+	dbClicks := self.dbClicks
+	if toDB {
+		self.dbClicks = make(map[string]int64)
+	}
+	self.clickLock.Unlock()
+	if !toDB || 0 == len(dbClicks) {
+		return
+	}
+	err := storage.GetInstance().AddClicks(ctx, dbClicks)
+	if nil == err {
+		return
+	}
+	log.FromContext(ctx).Error("add short url clicks to db err, retry next flush", zap.Int("links", len(dbClicks)), zap.Error(err))
+	self.clickLock.Lock()
+	for short_url, n := range dbClicks {
+		self.dbClicks[short_url] += n
+	}
+	self.clickLock.Unlock()
+}
+
+func (self *DataManager) GetClicks(ctx context.Context, short_url_info *common.ShortUrlInfo) int64 {
+	return storage.GetInstance().GetClicks(ctx, short_url_info)
+}
@@ -0,0 +1,18 @@
+// Package html embeds the pages and assets of this directory. Files holds
+// the assets, served instead of the directory when STATIC_EMBED is on, and
+// Templates the default page templates.
+package html
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed favicon.ico style.css
+var Files embed.FS
+
+//go:embed templates
+var templates embed.FS
+
+// Templates holds layout.html, the partials/ and one file per page
+var Templates, _ = fs.Sub(templates, "templates")
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/trace"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// accessLog writes one line per request to its own rotated file
+type accessLog struct {
+	writer    io.Writer
+	redirects uint64
+}
+
+type accessRecord struct {
+	Time      string  `json:"time"`
+	ClientIP  string  `json:"client_ip"`
+	Method    string  `json:"method"`
+	Uri       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMs float64 `json:"latency_ms"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+}
+
+// redactedParams are query parameters whose values are never logged
+var redactedParams = map[string]bool{"token": true, "password": true}
+
+// redactUri masks the values of redactedParams in the query of uri
+func redactUri(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		rawName, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(rawName)
+		if nil != err {
+			name = rawName
+		}
+		if redactedParams[strings.ToLower(name)] {
+			params[i] = rawName + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
+
+// statusWriter remembers what the handler wrote for the access log
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (self *statusWriter) WriteHeader(status int) {
+	if 0 == self.status {
+		self.status = status
+	}
+	self.ResponseWriter.WriteHeader(status)
+}
+
+func (self *statusWriter) Write(b []byte) (int, error) {
+	if 0 == self.status {
+		self.status = http.StatusOK
+	}
+	n, err := self.ResponseWriter.Write(b)
+	self.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streamed responses like archives flowing
+func (self *statusWriter) Flush() {
+	if 0 == self.status {
+		self.status = http.StatusOK
+	}
+	http.NewResponseController(self.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the writer below
+func (self *statusWriter) Unwrap() http.ResponseWriter {
+	return self.ResponseWriter
+}
+
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); "" != forwarded {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if nil != err {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400 && http.StatusNotModified != status
+}
+
+// sampled reports whether a request with status is logged, redirects are
+// logged 1 in sample times, everything else always
+func (self *accessLog) sampled(status, sample int) bool {
+	if sample <= 1 || !isRedirect(status) {
+		return true
+	}
+	return 0 == atomic.AddUint64(&self.redirects, 1)%uint64(sample)
+}
+
+func (self *accessLog) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if 0 == sw.status {
+			sw.status = http.StatusOK
+		}
+		conf := GetInstance().getConfig().Log.Access
+		if !self.sampled(sw.status, conf.Sample) {
+			return
+		}
+		rec := accessRecord{
+			Time:      start.Format(time.RFC3339),
+			ClientIP:  clientIP(r, conf.TrustProxy),
+			Method:    r.Method,
+			Uri:       redactUri(r.RequestURI),
+			Proto:     r.Proto,
+			Status:    sw.status,
+			Bytes:     sw.bytes,
+			LatencyMs: float64(time.Since(start).Nanoseconds()) / float64(time.Millisecond),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: trace.RequestID(r.Context()),
+		}
+		var err error
+		if common.AF_JSON == conf.Format {
+			err = self.writeJson(&rec)
+		} else {
+			err = self.writeCombined(&rec, start)
+		}
+		if nil != err {
+			log.FromContext(r.Context()).Error("write access log err", zap.Error(err))
+		}
+	})
+}
+
+func (self *accessLog) writeJson(rec *accessRecord) error {
+	line, err := json.Marshal(rec)
+	if nil != err {
+		return err
+	}
+	_, err = self.writer.Write(append(line, '\n'))
+	return err
+}
+
+// writeCombined writes the apache combined log format
+func (self *accessLog) writeCombined(rec *accessRecord, start time.Time) error {
+	size := "-"
+	if rec.Bytes > 0 {
+		size = strconv.FormatInt(rec.Bytes, 10)
+	}
+	line := rec.ClientIP + " - - [" + start.Format("02/Jan/2006:15:04:05 -0700") + "] " +
+		strconv.Quote(rec.Method+" "+rec.Uri+" "+rec.Proto) + " " +
+		strconv.Itoa(rec.Status) + " " + size + " " +
+		strconv.Quote(orDash(rec.Referer)) + " " + strconv.Quote(orDash(rec.UserAgent)) + "\n"
+	_, err := self.writer.Write([]byte(line))
+	return err
+}
+
+func orDash(str string) string {
+	if "" == str {
+		return "-"
+	}
+	return str
+}
+
+// newAccessLogHandler wraps next with the access log, next itself when disabled
+func newAccessLogHandler(writer io.Writer, next http.Handler) http.Handler {
+	if nil == writer {
+		return next
+	}
+	return (&accessLog{writer: writer}).wrap(next)
+}
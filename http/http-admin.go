@@ -0,0 +1,109 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+const ADMIN_TOKEN_HEADER = "X-Admin-Token"
+
+// handleLinkAdminRequest serves /api/link/{info,delete,disable,restore,purge,interstitial,password}
+func handleLinkAdminRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	logger.Debug(redactUri(r.RequestURI))
+	r.ParseForm()
+	if !GetInstance().checkAdminToken(r) {
+		writeJsonResult(w, http.StatusForbidden, errors.New(common.ERROR_VERIFY_NOT_PASS), nil)
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/api/link/")
+	short_url := r.Form.Get("short_url")
+	if "" == short_url {
+		writeJsonResult(w, http.StatusBadRequest, errors.New(common.ERROR_INVALID_PARAM), nil)
+		return
+	}
+	if "info" == action {
+		short_url_info, err := data.GetInstance().GetShortUrlInfo(ctx, short_url)
+		if nil != err {
+			writeJsonResult(w, http.StatusNotFound, err, nil)
+			return
+		}
+		writeJsonResult(w, http.StatusOK, nil, short_url_info)
+		return
+	}
+	if http.MethodPost != r.Method {
+		writeJsonResult(w, http.StatusMethodNotAllowed, errors.New(common.ERROR_INVALID_PARAM), nil)
+		return
+	}
+	var err error
+	switch action {
+	case "delete":
+		err = data.GetInstance().DeleteShortUrl(ctx, short_url)
+	case "disable":
+		err = data.GetInstance().DisableShortUrl(ctx, short_url, r.Form.Get("reason"))
+	case "restore":
+		err = data.GetInstance().RestoreShortUrl(ctx, short_url)
+	case "purge":
+		err = data.GetInstance().PurgeShortUrl(ctx, short_url)
+	case "interstitial":
+		err = data.GetInstance().SetInterstitial(ctx, short_url, "0" != r.Form.Get("on"))
+	case "password":
+		err = setPassword(ctx, short_url, r.PostForm.Get("password"))
+	default:
+		writeJsonResult(w, http.StatusNotFound, errors.New(common.ERROR_INVALID_PARAM), nil)
+		return
+	}
+	if nil != err {
+		logger.Error("link admin err", zap.String("action", action), zap.String("short url", short_url), zap.Error(err))
+		writeJsonResult(w, http.StatusNotFound, err, nil)
+		return
+	}
+	writeJsonResult(w, http.StatusOK, nil, nil)
+}
+
+// handleLogLevelRequest serves GET/PUT /admin/log/level, e.g. {"level":"debug"}
+func handleLogLevelRequest(w http.ResponseWriter, r *http.Request) {
+	if !GetInstance().checkAdminToken(r) {
+		writeJsonResult(w, http.StatusForbidden, errors.New(common.ERROR_VERIFY_NOT_PASS), nil)
+		return
+	}
+	level := log.GetInstance().GetLevel()
+	before := level.Level()
+	w.Header().Set("Content-Type", "application/json")
+	level.ServeHTTP(w, r)
+	if before != level.Level() {
+		log.FromContext(r.Context()).Warn("log level changed by admin", zap.Stringer("from", before), zap.Stringer("to", level.Level()))
+	}
+}
+
+// checkAdminToken only takes the token from the header, urls and forms end
+// up in access logs
+func (self *HttpManager) checkAdminToken(r *http.Request) bool {
+	adminToken := self.getConfig().AdminToken
+	if "" == adminToken {
+		return false
+	}
+	return 1 == subtle.ConstantTimeCompare([]byte(adminToken), []byte(r.Header.Get(ADMIN_TOKEN_HEADER)))
+}
+
+func writeJsonResult(w http.ResponseWriter, status int, err error, result interface{}) {
+	res := map[string]interface{}{common.CODE: common.SUCCESS}
+	if nil != err {
+		res[common.CODE] = common.FAIL
+		res[common.ERROR] = err.Error()
+	}
+	if nil != result {
+		res["data"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// PASSWORD_FREE_ATTEMPTS wrong passwords of one client on one link are
+	// answered at once, each further one doubles the wait before the next
+	PASSWORD_FREE_ATTEMPTS = 5
+	PASSWORD_MAX_BACKOFF   = 15 * time.Minute
+)
+
+// attempt counts the wrong passwords of one client on one link
+type attempt struct {
+	failures int
+	until    time.Time
+	last     time.Time
+}
+
+// attemptLimiter slows down password guessing per link and client address
+type attemptLimiter struct {
+	lock      sync.Mutex
+	attempts  map[string]*attempt
+	lastSweep time.Time
+}
+
+var passwordAttempts = &attemptLimiter{attempts: make(map[string]*attempt)}
+
+func attemptKey(short_url, ip string) string {
+	return short_url + "\x00" + ip
+}
+
+// wait returns how long the client has to wait before its next try
+func (self *attemptLimiter) wait(short_url, ip string, now time.Time) time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	a := self.attempts[attemptKey(short_url, ip)]
+	if nil == a || !now.Before(a.until) {
+		return 0
+	}
+	return a.until.Sub(now)
+}
+
+// fail records a wrong password and starts the backoff after the free tries
+func (self *attemptLimiter) fail(short_url, ip string, now time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.sweep(now)
+	key := attemptKey(short_url, ip)
+	a := self.attempts[key]
+	if nil == a {
+		a = &attempt{}
+		self.attempts[key] = a
+	}
+	a.failures++
+	a.last = now
+	if a.failures < PASSWORD_FREE_ATTEMPTS {
+		return
+	}
+	backoff := PASSWORD_MAX_BACKOFF
+	if shift := a.failures - PASSWORD_FREE_ATTEMPTS; shift < 20 {
+		backoff = time.Second << uint(shift)
+		if backoff > PASSWORD_MAX_BACKOFF {
+			backoff = PASSWORD_MAX_BACKOFF
+		}
+	}
+	a.until = now.Add(backoff)
+}
+
+// succeed forgets the failures of the client on the link
+func (self *attemptLimiter) succeed(short_url, ip string) {
+	self.lock.Lock()
+	delete(self.attempts, attemptKey(short_url, ip))
+	self.lock.Unlock()
+}
+
+// sweep drops clients idle for longer than the longest backoff, at most once
+// a minute; the caller holds the lock
+func (self *attemptLimiter) sweep(now time.Time) {
+	if now.Sub(self.lastSweep) < time.Minute {
+		return
+	}
+	self.lastSweep = now
+	for key, a := range self.attempts {
+		if now.Sub(a.last) > PASSWORD_MAX_BACKOFF && !now.Before(a.until) {
+			delete(self.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"errors"
+	"github.com/service-kit/short-url/bulk"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/util"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+const BULK_MAX_BODY = 64 * 1024 * 1024
+
+// handleBulkCreateRequest serves POST /api/link/bulk with a csv or ndjson body,
+// results are written back one per row in the same format
+func handleBulkCreateRequest(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context())
+	logger.Debug(redactUri(r.RequestURI))
+	if !GetInstance().checkAdminToken(r) {
+		writeJsonResult(w, http.StatusForbidden, errors.New(common.ERROR_VERIFY_NOT_PASS), nil)
+		return
+	}
+	if http.MethodPost != r.Method {
+		writeJsonResult(w, http.StatusMethodNotAllowed, errors.New(common.ERROR_INVALID_PARAM), nil)
+		return
+	}
+	format, err := bulk.DetectFormat(r.Header.Get("Content-Type"), r.URL.Query().Get("format"))
+	if nil != err {
+		writeJsonResult(w, http.StatusUnsupportedMediaType, err, nil)
+		return
+	}
+	conf := GetInstance().getConfig()
+	rows, err := bulk.ReadRows(http.MaxBytesReader(w, r.Body, BULK_MAX_BODY), format, conf.BulkMaxRows)
+	if nil != err {
+		writeJsonResult(w, http.StatusBadRequest, err, nil)
+		return
+	}
+	results := bulk.Create(r.Context(), rows, conf.BulkBatchSize, conf.ShortUrlHeader)
+	logger.Info("bulk create", zap.String("format", format), zap.Int("rows", len(rows)))
+	if bulk.FORMAT_CSV == format {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	err = bulk.WriteResults(w, format, results)
+	if nil != err {
+		logger.Error("write bulk results err", zap.Error(err))
+	}
+}
+
+// handleArchiveRequest serves /api/qr/archive, streaming a zip of the codes
+// listed in codes (comma separated or repeated) or tagged tag, rendered with
+// barcode= and the QR parameters
+func handleArchiveRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	r.ParseForm()
+	if !GetInstance().checkAdminToken(r) {
+		writeJsonResult(w, http.StatusForbidden, errors.New(common.ERROR_VERIFY_NOT_PASS), nil)
+		return
+	}
+	conf := GetInstance().getConfig()
+	opts := bulk.ArchiveOptions{Tag: r.Form.Get("tag"), Kind: util.BARCODE_QR, ShortUrlHeader: conf.ShortUrlHeader, MaxLinks: conf.BulkMaxRows}
+	for _, codes := range r.Form["codes"] {
+		for _, code := range strings.Split(codes, ",") {
+			if code = strings.TrimSpace(code); "" != code {
+				opts.Codes = append(opts.Codes, code)
+			}
+		}
+	}
+	var err error
+	if str := r.Form.Get("barcode"); "" != str {
+		if opts.Kind, err = util.ParseBarcodeKind(str); nil != err {
+			writeJsonResult(w, http.StatusBadRequest, err, nil)
+			return
+		}
+	}
+	if opts.Render, err = parseQROptions(r); nil != err {
+		writeJsonResult(w, http.StatusBadRequest, err, nil)
+		return
+	}
+	links, err := bulk.ArchiveLinks(ctx, opts)
+	if nil != err {
+		writeJsonResult(w, http.StatusBadRequest, err, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+opts.Kind+`-codes.zip"`)
+	// the status is out once streaming starts, failures only show in the log
+	count, err := bulk.WriteArchive(ctx, w, links, opts)
+	if nil != err {
+		logger.Error("write archive err", zap.Int("codes", count), zap.Error(err))
+		return
+	}
+	logger.Info("archive", zap.String("tag", opts.Tag), zap.Int("codes", count))
+}
@@ -1,39 +1,73 @@
 package http
 
 import (
+	"bytes"
 	"github.com/service-kit/short-url/common"
 	"github.com/service-kit/short-url/data"
-	"github.com/service-kit/short-url/storage"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/page"
+	"github.com/service-kit/short-url/policy"
 	"github.com/service-kit/short-url/util"
 	"go.uber.org/zap"
-	"html/template"
-	"io"
 	"net/http"
 	"net/url"
-	"os"
-	"strings"
+	"strconv"
 )
 
 func handleShortUrlRequest(w http.ResponseWriter, r *http.Request) {
-	logger.Info(r.RequestURI)
-	if "/" != r.RequestURI {
-		short_url := r.RequestURI[1:]
-		if strings.Contains(short_url, "cache/") && strings.Contains(short_url, ".jpg") {
-			downJpg(w, short_url)
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	logger.Debug(redactUri(r.RequestURI))
+	if "/" != r.URL.Path {
+		short_url := r.URL.Path[1:]
+		if common.FAVICON_ICO == short_url {
+			GetInstance().static.serve(w, r, common.FAVICON_ICO)
 			return
 		}
-		if common.FAVICON_ICO == short_url {
-			downFaviconIco(w)
+		if name, ok := staticPath(r.URL.Path); ok {
+			GetInstance().static.serve(w, r, name)
+			return
+		}
+		if code, kind, ok := barcodePath(r.URL.Path); ok {
+			handleBarcodeRequest(w, r, code, kind)
+			return
+		}
+		short_url, preview := previewCode(r, short_url)
+		logger.Info("short url request", zap.String("short url", short_url), zap.Bool("preview", preview))
+		short_url_info, err := data.GetInstance().GetLiveShortUrlInfo(ctx, short_url)
+		if nil != err || "" == short_url_info.OriginalUrl {
+			fillErrorHtml(w, r, http.StatusNotFound, "This short url does not exist.")
+			return
+		}
+		if short_url_info.IsExpired(util.GetCurrentSeconds()) {
+			fillErrorHtml(w, r, http.StatusGone, "This short url has expired.")
+			return
+		}
+		switch short_url_info.Status {
+		case common.LS_DELETED:
+			fillErrorHtml(w, r, http.StatusGone, "This short url has been deleted.")
+			return
+		case common.LS_DISABLED:
+			logger.Info("short url disabled", zap.String("short url", short_url))
+			fillDisabledHtml(w, r, short_url_info)
 			return
 		}
-		logger.Info("short url request", zap.String("short url", short_url))
-		original_url, err := storage.GetInstance().GetOriginalUrl(short_url)
-		if nil != err || "" == original_url {
-			w.WriteHeader(http.StatusNotFound)
+		// rules may have changed since the link was created
+		if reason := policy.GetInstance().Blocked(short_url_info.OriginalUrl); "" != reason {
+			logger.Warn("short url blocked", zap.String("short url", short_url), zap.String("reason", reason))
+			fillBlockedHtml(w, r, short_url_info, reason)
 			return
 		}
-		logger.Info("redirect to original url", zap.String("original url", original_url))
-		http.Redirect(w, r, original_url, http.StatusMovedPermanently)
+		if "" != short_url_info.PasswordHash && !isUnlocked(r, short_url_info) && !unlock(w, r, short_url_info) {
+			return
+		}
+		if preview || needsInterstitial(short_url_info) {
+			fillPreviewHtml(w, r, short_url_info, !preview)
+			return
+		}
+		data.GetInstance().CountClick(short_url)
+		logger.Info("redirect to original url", zap.String("original url", short_url_info.OriginalUrl))
+		http.Redirect(w, r, short_url_info.OriginalUrl, redirectStatus(short_url_info))
 		return
 	}
 	r.ParseForm()
@@ -46,72 +80,66 @@ func handleShortUrlRequest(w http.ResponseWriter, r *http.Request) {
 	original_url := form.Get("original_url")
 	if "" == original_url {
 		logger.Info("get index html")
-		GetInstance().outputHTML(w, r, "./html/index.html")
+		fillHtmlData(w, r, http.StatusOK, page.PAGE_INDEX, nil)
 		return
 	}
-	short_url := form.Get("short_url")
-	if "" == short_url {
-		logger.Info("use system create short url")
-		short_url = util.BuildShortUrl(original_url)
-	} else {
-		res, _ := data.GetInstance().GetOriginalUrl(short_url)
-		if original_url != res {
-			w.Write([]byte(short_url + " has exist"))
-			return
-		}
+	short_url_info := new(common.ShortUrlInfo)
+	short_url_info.OriginalUrl = original_url
+	short_url_info.ShortUrl = form.Get("short_url")
+	short_url_info.Interstitial = "1" == form.Get("interstitial")
+	var err error
+	if password := form.Get("password"); "" != password {
+		short_url_info.PasswordHash, err = util.HashPassword(ctx, password)
+		// keep it out of the log
+		form.Del("password")
 	}
-	err := data.GetInstance().AddNewShortUrl(original_url, short_url)
-	if nil != err {
-		w.Write([]byte(short_url + " add err"))
-		return
+	if nil == err {
+		err = data.GetInstance().CreateShortUrl(ctx, short_url_info)
 	}
-	logger.Info("register", zap.Any("param", form))
-	fullShortUrl := GetInstance().shortUrlHeader + short_url
-	jpgData, err := util.BuildQRCodeJpg(fullShortUrl)
-	if nil != err {
+	if util.ErrPasswordBusy == err {
+		w.Header().Set("Retry-After", "1")
+		fillErrorHtml(w, r, http.StatusServiceUnavailable, "Too busy to protect the link by password, please try again.")
 		return
 	}
-	cacheFileName := "./cache/" + short_url + ".jpg"
-	util.SaveFile("./html/"+cacheFileName, jpgData)
-	err = fillRegisterResultHtml(w, original_url, fullShortUrl, cacheFileName)
 	if nil != err {
-		logger.Error("fill register result html err", zap.Error(err))
+		fillErrorHtml(w, r, http.StatusBadRequest, "This short url can not be created: "+err.Error())
+		return
 	}
+	short_url := short_url_info.ShortUrl
+	logger.Info("register", zap.Any("param", form))
+	fullShortUrl := GetInstance().getConfig().ShortUrlHeader + short_url
+	qrUrl := "./" + url.PathEscape(short_url) + QR_PATH_SUFFIX + "?size=" + strconv.Itoa(REGISTER_QR_SIZE)
+	fillRegisterResultHtml(w, r, original_url, fullShortUrl, qrUrl)
 }
 
-func downJpg(w http.ResponseWriter, url string) error {
-	cacheFile, err := os.OpenFile("./html/"+url, os.O_RDONLY, 0777)
-	if nil != err {
-		return err
-	}
-	w.Header().Set("Content-Type", "image/jpg")
-	_, err = io.Copy(w, cacheFile)
-	return err
+func fillRegisterResultHtml(w http.ResponseWriter, r *http.Request, oriUrl, shortUrl, qrjpg string) error {
+	return fillHtmlData(w, r, http.StatusOK, page.PAGE_REGISTER_RESULT, map[string]string{"ORIURL": oriUrl, "SHORTURL": shortUrl, "QRJPG": qrjpg})
 }
 
-func downFaviconIco(w http.ResponseWriter) error {
-	f, err := os.OpenFile("./html/favicon.ico", os.O_RDONLY, 0777)
-	if nil != err {
-		return err
-	}
-	w.Header().Set("Content-Type", "image/icon")
-	_, err = io.Copy(w, f)
-	return err
+func fillDisabledHtml(w http.ResponseWriter, r *http.Request, short_url_info *common.ShortUrlInfo) error {
+	return fillHtmlData(w, r, http.StatusForbidden, page.PAGE_DISABLED, map[string]string{"SHORTURL": short_url_info.ShortUrl, "REASON": short_url_info.Reason})
 }
 
-func fillRegisterResultHtml(w http.ResponseWriter, oriUrl, shortUrl, qrjpg string) error {
-	return fillHtmlData(w, map[string]string{"ORIURL": oriUrl, "SHORTURL": shortUrl, "QRJPG": qrjpg}, "./html/register_result.html")
+func fillBlockedHtml(w http.ResponseWriter, r *http.Request, short_url_info *common.ShortUrlInfo, reason string) error {
+	return fillHtmlData(w, r, http.StatusForbidden, page.PAGE_BLOCKED, map[string]string{"SHORTURL": short_url_info.ShortUrl, "REASON": reason})
 }
 
-func fillHtmlData(w http.ResponseWriter, data map[string]string, htmls ...string) error {
-	t, err := template.ParseFiles(htmls...)
+func fillErrorHtml(w http.ResponseWriter, r *http.Request, status int, detail string) error {
+	return fillHtmlData(w, r, status, page.PAGE_ERROR, map[string]string{"STATUS": strconv.Itoa(status), "MESSAGE": http.StatusText(status), "DETAIL": detail})
+}
+
+// fillHtmlData renders the page before writing status, so a failing
+// template still answers with a proper error
+func fillHtmlData(w http.ResponseWriter, r *http.Request, status int, name string, data map[string]string) error {
+	buf := new(bytes.Buffer)
+	err := page.GetInstance().Render(buf, name, data)
 	if nil != err {
-		logger.Error("template parse files err", zap.Error(err))
+		log.FromContext(r.Context()).Error("render page err", zap.String("page", name), zap.Error(err))
+		http.Error(w, http.StatusText(status), status)
 		return err
 	}
-	err = t.Execute(w, data)
-	if nil != err {
-		logger.Error("template execute err", zap.Error(err))
-	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = w.Write(buf.Bytes())
 	return err
 }
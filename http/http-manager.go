@@ -1,19 +1,25 @@
 package http
 
 import (
+	"errors"
 	"github.com/service-kit/short-url/common"
 	"github.com/service-kit/short-url/config"
 	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/trace"
 	"go.uber.org/zap"
+	"io"
 	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 type HttpManager struct {
-	addr           string
-	shortUrlHeader string
-	wg             *sync.WaitGroup
+	addr         string
+	conf         atomic.Value
+	accessWriter io.Writer
+	static       *staticFiles
+	wg           *sync.WaitGroup
 }
 
 var m *HttpManager
@@ -30,35 +36,70 @@ func GetInstance() *HttpManager {
 func (self *HttpManager) InitManager(wg *sync.WaitGroup) error {
 	logger = log.GetInstance().GetLogger()
 	self.wg = wg
-	var err error = nil
-	self.addr, err = config.GetInstance().GetConfig("SHORT_URL_HTTP_ADDR")
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	self.addr = conf.HttpAddr
+	self.conf.Store(conf)
+	if "" != conf.Log.Access.Path {
+		self.accessWriter = log.NewRotateWriter(conf.Log.Access.Path, conf.Log)
+	}
+	self.initTraceExporter(conf)
+	var err error
+	self.static, err = newStaticFiles(conf)
 	if nil != err {
 		return err
 	}
-	self.shortUrlHeader, err = config.GetInstance().GetConfig("SHORT_URL_HEADER")
-	if "" == self.shortUrlHeader {
-		logger.Warn("short url header nil")
-		self.shortUrlHeader = common.SHORT_URL_HEADER
+	if "" == conf.AdminToken {
+		logger.Warn("admin token nil, link admin api disabled")
 	}
+	warnCookieSecret(conf)
+	config.GetInstance().Subscribe(self.onConfigChange)
 	self.wg.Add(1)
 	http.HandleFunc("/", handleShortUrlRequest)
+	http.HandleFunc("/api/link/", handleLinkAdminRequest)
+	http.HandleFunc("/api/link/bulk", handleBulkCreateRequest)
+	http.HandleFunc("/api/qr/payload", handlePayloadApiRequest)
+	http.HandleFunc("/api/qr/archive", handleArchiveRequest)
+	http.HandleFunc(PAYLOAD_PATH, handlePayloadRequest)
+	http.HandleFunc(PAYLOAD_OPEN_PATH, handlePayloadOpenRequest)
+	http.HandleFunc("/admin/log/level", handleLogLevelRequest)
 	go self.startHttpServer()
 	return nil
 }
 
-func (self *HttpManager) startHttpServer() {
-	logger.Info("Start Http Server", zap.String("addr", self.addr))
-	defer self.wg.Done()
-	http.ListenAndServe(self.addr, nil)
+// getConfig returns the config snapshot handlers should use for one request
+func (self *HttpManager) getConfig() *config.Config {
+	return self.conf.Load().(*config.Config)
 }
 
-func (self *HttpManager) outputHTML(w http.ResponseWriter, req *http.Request, filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+func (self *HttpManager) onConfigChange(change *config.ConfigChange) {
+	self.conf.Store(change.New)
+	logger.Info("http config reloaded", zap.String("short url header", change.New.ShortUrlHeader))
+	if "" != change.Old.CookieSecret {
+		warnCookieSecret(change.New)
+	}
+}
+
+// initTraceExporter installs the span exporter chosen by TRACE_EXPORTER,
+// spans are dropped when it is empty
+func (self *HttpManager) initTraceExporter(conf *config.Config) {
+	switch conf.Trace.Exporter {
+	case common.TE_STDOUT:
+		trace.SetExporter(trace.NewWriterExporter(os.Stdout, conf.Log.Name))
+	case common.TE_FILE:
+		trace.SetExporter(trace.NewWriterExporter(log.NewRotateWriter(conf.Trace.File, conf.Log), conf.Log.Name))
+	default:
 		return
 	}
-	defer file.Close()
-	fi, _ := file.Stat()
-	http.ServeContent(w, req, file.Name(), fi.ModTime(), file)
+	logger.Info("trace exporter on", zap.String("exporter", conf.Trace.Exporter))
+}
+
+func (self *HttpManager) startHttpServer() {
+	logger.Info("Start Http Server", zap.String("addr", self.addr))
+	defer self.wg.Done()
+	handler := newTraceHandler(newAccessLogHandler(self.accessWriter, http.DefaultServeMux))
+	err := http.ListenAndServe(self.addr, handler)
+	logger.Error("http server stopped", zap.Error(err))
 }
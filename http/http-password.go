@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/page"
+	"github.com/service-kit/short-url/util"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PASSWORD_COOKIE + code names the unlock cookie of a link, on path / so it
+// covers the preview too
+const PASSWORD_COOKIE = "short_url_unlock_"
+
+// randomCookieKey signs cookies when COOKIE_SECRET is empty, unlocked links
+// lock again on restart and on other instances
+var randomCookieKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); nil != err {
+		panic("read random cookie key err: " + err.Error())
+	}
+	return key
+}()
+
+// warnCookieSecret tells that unlock cookies only hold on this instance
+func warnCookieSecret(conf *config.Config) {
+	if "" == conf.CookieSecret {
+		logger.Warn("COOKIE_SECRET is empty, password links unlocked on one instance stay locked on others and after a restart")
+	}
+}
+
+func cookieKey() []byte {
+	if secret := GetInstance().getConfig().CookieSecret; "" != secret {
+		return []byte(secret)
+	}
+	return randomCookieKey
+}
+
+// unlockSignature binds the cookie to the link, its expiry and its password
+// hash, so a changed password locks the link again
+func unlockSignature(short_url_info *common.ShortUrlInfo, expire string) []byte {
+	mac := hmac.New(sha256.New, cookieKey())
+	mac.Write([]byte(short_url_info.ShortUrl + "\x00" + expire + "\x00" + short_url_info.PasswordHash))
+	return mac.Sum(nil)
+}
+
+// isUnlocked reports whether the request carries a valid unlock cookie
+func isUnlocked(r *http.Request, short_url_info *common.ShortUrlInfo) bool {
+	cookie, err := r.Cookie(PASSWORD_COOKIE + url.QueryEscape(short_url_info.ShortUrl))
+	if nil != err {
+		return false
+	}
+	expire, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	sec, err := strconv.ParseInt(expire, 10, 64)
+	if nil != err || sec <= util.GetCurrentSeconds() {
+		return false
+	}
+	want, err := base64.RawURLEncoding.DecodeString(sig)
+	return nil == err && hmac.Equal(want, unlockSignature(short_url_info, expire))
+}
+
+func setUnlockCookie(w http.ResponseWriter, short_url_info *common.ShortUrlInfo) {
+	conf := GetInstance().getConfig()
+	expire := strconv.FormatInt(util.GetCurrentSeconds()+int64(conf.PasswordTTL), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     PASSWORD_COOKIE + url.QueryEscape(short_url_info.ShortUrl),
+		Value:    expire + "." + base64.RawURLEncoding.EncodeToString(unlockSignature(short_url_info, expire)),
+		Path:     "/",
+		MaxAge:   conf.PasswordTTL,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(conf.ShortUrlHeader, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// unlock checks the posted password of a protected link, it renders the
+// password form and returns false until the password is right. Clients
+// guessing wrong are made to wait before the hash is computed again
+func unlock(w http.ResponseWriter, r *http.Request, short_url_info *common.ShortUrlInfo) bool {
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	status, message := http.StatusUnauthorized, ""
+	if http.MethodPost == r.Method {
+		short_url := short_url_info.ShortUrl
+		ip := clientIP(r, GetInstance().getConfig().Log.Access.TrustProxy)
+		now := time.Now()
+		if wait := passwordAttempts.wait(short_url, ip, now); wait > 0 {
+			seconds := strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+			logger.Info("short url password attempts limited", zap.String("short url", short_url), zap.String("client ip", ip))
+			w.Header().Set("Retry-After", seconds)
+			status, message = http.StatusTooManyRequests, "Too many wrong passwords, please try again after "+seconds+"s."
+		} else {
+			password := r.PostFormValue("password")
+			ok, err := util.CheckPassword(ctx, short_url_info.PasswordHash, password)
+			switch {
+			case nil != err:
+				logger.Warn("short url password check skipped", zap.String("short url", short_url), zap.Error(err))
+				w.Header().Set("Retry-After", "1")
+				status, message = http.StatusServiceUnavailable, "Too busy to check the password, please try again."
+			case ok:
+				logger.Info("short url unlocked", zap.String("short url", short_url))
+				passwordAttempts.succeed(short_url, ip)
+				setUnlockCookie(w, short_url_info)
+				return true
+			default:
+				logger.Info("short url wrong password", zap.String("short url", short_url), zap.String("client ip", ip))
+				passwordAttempts.fail(short_url, ip, now)
+				message = "Wrong password, please try again."
+			}
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	fillHtmlData(w, r, status, page.PAGE_PASSWORD, map[string]string{
+		"SHORTURL": GetInstance().getConfig().ShortUrlHeader + short_url_info.ShortUrl,
+		"ERROR":    message,
+	})
+	return false
+}
+
+// setPassword protects the link by password, an empty one removes it
+func setPassword(ctx context.Context, short_url, password string) error {
+	var password_hash string
+	if "" != password {
+		var err error
+		password_hash, err = util.HashPassword(ctx, password)
+		if nil != err {
+			return err
+		}
+		warnCookieSecret(GetInstance().getConfig())
+	}
+	return data.GetInstance().SetPasswordHash(ctx, short_url, password_hash)
+}
+
+// redirectStatus keeps browsers from caching the redirect of protected
+// links, which would skip the password next time
+func redirectStatus(short_url_info *common.ShortUrlInfo) int {
+	if "" != short_url_info.PasswordHash {
+		return http.StatusSeeOther
+	}
+	return http.StatusMovedPermanently
+}
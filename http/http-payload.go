@@ -0,0 +1,140 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/page"
+	"github.com/service-kit/short-url/payload"
+	"github.com/service-kit/short-url/util"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	PAYLOAD_PATH      = common.PAYLOAD_PATH
+	PAYLOAD_OPEN_PATH = common.PAYLOAD_OPEN_PATH
+)
+
+// handlePayloadRequest serves /qr/payload, the form page without a type, else
+// the QR code of the payload built from the form; wrap=1 encodes a short link
+// opening the payload instead, so scans are counted. Wrapping creates a link
+// like the create form, so it is only posted
+func handlePayloadRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	r.ParseForm()
+	kind := r.Form.Get("type")
+	if "" == kind {
+		fillHtmlData(w, r, http.StatusOK, page.PAGE_PAYLOAD, nil)
+		return
+	}
+	p, err := payload.Build(kind, r.Form)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := parseQROptions(r)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	content := p.Text
+	if "1" == r.Form.Get("wrap") {
+		if http.MethodPost != r.Method {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "wrap=1 needs a post", http.StatusMethodNotAllowed)
+			return
+		}
+		content, err = wrapPayload(ctx, p)
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	img, err := util.BuildQRCode(content, opts)
+	if nil != err {
+		logger.Info("build payload qr code err", zap.String("type", kind), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// payloads may hold credentials, keep them out of shared caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", util.QRContentType(opts.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	w.Write(img)
+}
+
+// wrapPayload registers a short link to the open page of p and returns its url
+func wrapPayload(ctx context.Context, p *payload.Payload) (string, error) {
+	if !p.Openable() {
+		return "", errors.New(p.Type + " payloads can not be wrapped in a short link")
+	}
+	conf := GetInstance().getConfig()
+	header := conf.ShortUrlHeader
+	short_url_info := &common.ShortUrlInfo{OriginalUrl: header + strings.TrimPrefix(PAYLOAD_OPEN_PATH, "/") + "?" + p.Query.Encode()}
+	// the whole payload goes into the url, long ones do not fit
+	if len(short_url_info.OriginalUrl) > conf.Url.MaxLength {
+		return "", errors.New(p.Type + " payload is too long to wrap, its url has " + strconv.Itoa(len(short_url_info.OriginalUrl)) +
+			" characters, at most " + strconv.Itoa(conf.Url.MaxLength) + " fit")
+	}
+	err := data.GetInstance().CreateShortUrl(ctx, short_url_info)
+	if nil != err {
+		return "", err
+	}
+	log.FromContext(ctx).Info("payload wrapped", zap.String("type", p.Type), zap.String("short url", short_url_info.ShortUrl))
+	return header + short_url_info.ShortUrl, nil
+}
+
+// handlePayloadOpenRequest serves /qr/payload/open, the target of wrapped
+// payloads: contacts and events download as files, sms and geo redirect
+func handlePayloadOpenRequest(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p, err := payload.Build(r.Form.Get("type"), r.Form)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if "" != p.Uri {
+		http.Redirect(w, r, p.Uri, http.StatusFound)
+		return
+	}
+	if "" == p.File {
+		http.Error(w, p.Type+" payloads can not be opened", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", p.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+p.FileName+`"`)
+	w.Write([]byte(p.File))
+}
+
+// handlePayloadApiRequest serves /api/qr/payload, answering the payload text
+// and, with wrap=1, the short link and the url of its QR code
+func handlePayloadApiRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	r.ParseForm()
+	if !GetInstance().checkAdminToken(r) {
+		writeJsonResult(w, http.StatusForbidden, errors.New(common.ERROR_VERIFY_NOT_PASS), nil)
+		return
+	}
+	p, err := payload.Build(r.Form.Get("type"), r.Form)
+	if nil != err {
+		writeJsonResult(w, http.StatusBadRequest, err, nil)
+		return
+	}
+	result := map[string]string{"type": p.Type, "text": p.Text}
+	if "1" == r.Form.Get("wrap") {
+		short_url, err := wrapPayload(ctx, p)
+		if nil != err {
+			writeJsonResult(w, http.StatusBadRequest, err, nil)
+			return
+		}
+		result["short_url"] = short_url
+		result["qr"] = short_url + QR_PATH_SUFFIX
+	}
+	writeJsonResult(w, http.StatusOK, nil, result)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/page"
+	"github.com/service-kit/short-url/policy"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PREVIEW_SUFFIX appended to a code, like ?preview=1, shows the preview page
+const PREVIEW_SUFFIX = "+"
+
+// previewCode strips the preview suffix from short_url and reports whether
+// the request asks for the preview page
+func previewCode(r *http.Request, short_url string) (string, bool) {
+	if strings.HasSuffix(short_url, PREVIEW_SUFFIX) {
+		return strings.TrimSuffix(short_url, PREVIEW_SUFFIX), true
+	}
+	return short_url, "1" == r.URL.Query().Get("preview")
+}
+
+// needsInterstitial reports whether the link shows the preview page instead
+// of redirecting, by its own choice or by INTERSTITIAL
+func needsInterstitial(short_url_info *common.ShortUrlInfo) bool {
+	if short_url_info.Interstitial {
+		return true
+	}
+	switch GetInstance().getConfig().Interstitial {
+	case common.IM_ALL:
+		return true
+	case common.IM_UNTRUSTED:
+		return !policy.GetInstance().Trusted(short_url_info.OriginalUrl)
+	}
+	return false
+}
+
+// fillPreviewHtml shows where the link leads, the interstitial counts as the
+// click the redirect would have
+func fillPreviewHtml(w http.ResponseWriter, r *http.Request, short_url_info *common.ShortUrlInfo, interstitial bool) error {
+	ctx := r.Context()
+	if interstitial {
+		data.GetInstance().CountClick(short_url_info.ShortUrl)
+	}
+	var domain, created string
+	if u, err := url.Parse(short_url_info.OriginalUrl); nil == err {
+		domain = u.Hostname()
+	}
+	if 0 != short_url_info.CreateTime {
+		created = time.Unix(short_url_info.CreateTime, 0).UTC().Format("2006-01-02 15:04 UTC")
+	}
+	values := map[string]string{
+		"SHORTURL": GetInstance().getConfig().ShortUrlHeader + short_url_info.ShortUrl,
+		"ORIURL":   short_url_info.OriginalUrl,
+		"DOMAIN":   domain,
+		"CREATED":  created,
+		"CLICKS":   strconv.FormatInt(data.GetInstance().GetClicks(ctx, short_url_info), 10),
+		"QRURL":    "./" + url.PathEscape(short_url_info.ShortUrl) + QR_PATH_SUFFIX + "?size=" + strconv.Itoa(REGISTER_QR_SIZE),
+	}
+	if interstitial {
+		values["INTERSTITIAL"] = "1"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return fillHtmlData(w, r, http.StatusOK, page.PAGE_PREVIEW, values)
+}
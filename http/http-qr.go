@@ -0,0 +1,191 @@
+package http
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/service-kit/short-url/brand"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/qrcache"
+	"github.com/service-kit/short-url/util"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	QR_PATH_SUFFIX = "/qr"
+	// QR_MAX_AGE lets clients and proxies keep a code for five minutes, so
+	// codes of a disabled link do not linger
+	QR_MAX_AGE = 300
+	// QR_PUBLIC_MAX_SIZE bounds the size of anonymous requests, larger ones
+	// up to util.QR_MAX_SIZE need the admin token
+	QR_PUBLIC_MAX_SIZE = 1024
+	// REGISTER_QR_SIZE is the code shown on the register result page
+	REGISTER_QR_SIZE = 200
+
+	QR_STYLE_SQUARE  = "square"
+	QR_STYLE_ROUNDED = "rounded"
+)
+
+// parseQROptions reads brand, size, format, ecc, margin, module, fg, bg,
+// style and logo from the query or form, all optional; the brand comes first
+// so the rest override it
+func parseQROptions(r *http.Request) (util.QROptions, error) {
+	r.ParseForm()
+	query := r.Form
+	opts := util.DefaultQROptions()
+	var err error
+	var b *brand.Brand
+	if name := query.Get("brand"); "" != name {
+		b = brand.GetInstance().Get(name)
+		if nil == b {
+			return opts, errors.New("unknown brand: " + name)
+		}
+	} else {
+		b = brand.GetInstance().ForHost(r.Host)
+	}
+	if nil != b {
+		b.Apply(&opts)
+	}
+	if str := query.Get("format"); "" != str {
+		opts.Format, err = util.ParseQRFormat(str)
+		if nil != err {
+			return opts, err
+		}
+	}
+	if str := query.Get("ecc"); "" != str {
+		opts.Level, err = util.ParseQRLevel(str)
+		if nil != err {
+			return opts, err
+		}
+	}
+	if str := query.Get("size"); "" != str {
+		opts.Size, err = strconv.Atoi(str)
+		if nil != err {
+			return opts, errors.New("invalid size: " + str)
+		}
+		if opts.Size > QR_PUBLIC_MAX_SIZE && !GetInstance().checkAdminToken(r) {
+			return opts, errors.New("size above " + strconv.Itoa(QR_PUBLIC_MAX_SIZE) + " needs the admin token")
+		}
+	}
+	if str := query.Get("margin"); "" != str {
+		opts.Margin, err = strconv.Atoi(str)
+		if nil != err {
+			return opts, errors.New("invalid margin: " + str)
+		}
+	}
+	if str := query.Get("module"); "" != str {
+		opts.ModuleSize, err = strconv.ParseFloat(str, 64)
+		if nil != err {
+			return opts, errors.New("invalid module size: " + str)
+		}
+	}
+	if str := query.Get("fg"); "" != str {
+		opts.Foreground, err = util.ParseColor(str)
+		if nil != err {
+			return opts, err
+		}
+	}
+	if str := query.Get("bg"); "" != str {
+		opts.Background, err = util.ParseColor(str)
+		if nil != err {
+			return opts, err
+		}
+	}
+	switch query.Get("style") {
+	case "":
+	case QR_STYLE_SQUARE:
+		opts.Rounded = false
+	case QR_STYLE_ROUNDED:
+		opts.Rounded = true
+	default:
+		return opts, errors.New("invalid style: " + query.Get("style"))
+	}
+	if "0" == query.Get("logo") {
+		opts.Logo, opts.LogoName, opts.LogoHash = nil, "", ""
+	}
+	return opts, nil
+}
+
+// barcodeKey identifies the image, it only depends on the symbology, the
+// encoded url and options
+func barcodeKey(kind, content string, opts util.QROptions) string {
+	return kind + "\n" + content + "\n" + opts.Key()
+}
+
+func barcodeETag(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if "*" == tag || etag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// barcodePath splits /{code}/{kind} where kind is a barcode symbology
+func barcodePath(path string) (short_url, kind string, ok bool) {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "", "", false
+	}
+	kind, err := util.ParseBarcodeKind(path[i+1:])
+	if nil != err {
+		return "", "", false
+	}
+	return path[1:i], kind, true
+}
+
+// handleBarcodeRequest serves GET /{code}/{qr|datamatrix|aztec|pdf417|code128}
+// ?size=&format=png|jpg|svg|pdf&ecc=L|M|Q|H&margin=&module=&fg=&bg=&style=&brand=&logo=0
+func handleBarcodeRequest(w http.ResponseWriter, r *http.Request, short_url, kind string) {
+	ctx := r.Context()
+	logger := log.FromContext(ctx)
+	if http.MethodGet != r.Method && http.MethodHead != r.Method {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	short_url_info, err := data.GetInstance().GetShortUrlInfo(ctx, short_url)
+	if nil != err || common.LS_ACTIVE != short_url_info.Status || short_url_info.IsExpired(util.GetCurrentSeconds()) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	opts, err := parseQROptions(r)
+	if nil != err {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts = opts.ForKind(kind)
+	content := GetInstance().getConfig().ShortUrlHeader + short_url
+	key := barcodeKey(kind, content, opts)
+	etag := barcodeETag(key)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(QR_MAX_AGE))
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	img, err := qrcache.GetInstance().Get(ctx, short_url, key, func() ([]byte, error) {
+		return util.BuildBarcode(kind, content, opts)
+	})
+	if nil != err {
+		logger.Info("build barcode err", zap.String("short url", short_url), zap.String("kind", kind), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", util.QRContentType(opts.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+	if http.MethodHead == r.Method {
+		return
+	}
+	w.Write(img)
+}
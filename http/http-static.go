@@ -0,0 +1,142 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/html"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const STATIC_PATH_PREFIX = common.STATIC_PATH_PREFIX
+
+// staticTypes override the system mime table, which may lack these or name
+// them differently
+var staticTypes = map[string]string{
+	".ico":  "image/x-icon",
+	".jpg":  "image/jpeg",
+	".html": "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "text/javascript; charset=utf-8",
+	".svg":  "image/svg+xml",
+}
+
+// staticEntry is a file read once and kept until its size or time changes
+type staticEntry struct {
+	content []byte
+	modTime time.Time
+	etag    string
+}
+
+// staticFiles serves the files under one root, paths can not leave it:
+// fs.ValidPath refuses .. and os.Root refuses symlinks pointing outside
+type staticFiles struct {
+	root    fs.FS
+	started time.Time
+	lock    sync.RWMutex
+	entries map[string]*staticEntry
+}
+
+func newStaticFiles(conf *config.Config) (*staticFiles, error) {
+	files := &staticFiles{started: time.Now(), entries: make(map[string]*staticEntry)}
+	if conf.StaticEmbed {
+		files.root = html.Files
+		return files, nil
+	}
+	root, err := os.OpenRoot(conf.StaticDir)
+	if nil != err {
+		return nil, errors.New("open static dir err: " + err.Error())
+	}
+	files.root = root.FS()
+	return files, nil
+}
+
+// staticPath returns the file name of /static/ requests
+func staticPath(p string) (string, bool) {
+	if !strings.HasPrefix(p, STATIC_PATH_PREFIX) {
+		return "", false
+	}
+	return p[len(STATIC_PATH_PREFIX):], true
+}
+
+func staticType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := staticTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); "" != t {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func (self *staticFiles) load(name string) (*staticEntry, error) {
+	if !fs.ValidPath(name) || "." == name {
+		return nil, fs.ErrNotExist
+	}
+	f, err := self.root.Open(name)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	// embedded files have no time, they can not change while running
+	modTime := fi.ModTime()
+	if modTime.IsZero() {
+		modTime = self.started
+	}
+	self.lock.RLock()
+	entry := self.entries[name]
+	self.lock.RUnlock()
+	if nil != entry && entry.modTime.Equal(modTime) && int64(len(entry.content)) == fi.Size() {
+		return entry, nil
+	}
+	content, err := io.ReadAll(f)
+	if nil != err {
+		return nil, err
+	}
+	h := fnv.New64a()
+	h.Write(content)
+	entry = &staticEntry{content: content, modTime: modTime, etag: `"` + strconv.FormatUint(h.Sum64(), 36) + `"`}
+	self.lock.Lock()
+	self.entries[name] = entry
+	self.lock.Unlock()
+	return entry, nil
+}
+
+// serve writes the file name with its type, ETag and Last-Modified, and
+// answers conditional and range requests
+func (self *staticFiles) serve(w http.ResponseWriter, r *http.Request, name string) {
+	entry, err := self.load(name)
+	if nil != err {
+		// missing, a directory or outside the root
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", staticType(name))
+	w.Header().Set("ETag", entry.etag)
+	// pages change with a deploy, assets rarely
+	if strings.HasSuffix(name, ".html") {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	http.ServeContent(w, r, name, entry.modTime, bytes.NewReader(entry.content))
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"errors"
+	"github.com/service-kit/short-url/trace"
+	"github.com/service-kit/short-url/util"
+	"net/http"
+	"strconv"
+)
+
+// MAX_REQUEST_ID_LEN bounds a request id taken from the client
+const MAX_REQUEST_ID_LEN = 128
+
+// requestID takes the client's X-Request-ID when sane, else makes a new one
+func requestID(r *http.Request) string {
+	id := r.Header.Get(trace.HEADER_REQUEST_ID)
+	if "" == id || len(id) > MAX_REQUEST_ID_LEN {
+		return util.NewUUID()
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return util.NewUUID()
+		}
+	}
+	return id
+}
+
+// newTraceHandler gives every request an id and a root span continuing the
+// caller's traceparent; both are echoed in the response headers
+func newTraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		remote, _ := trace.ParseTraceparent(r.Header.Get(trace.HEADER_TRACEPARENT))
+		ctx, span := trace.StartRemote(trace.WithRequestID(r.Context(), id), "http.request", remote)
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("request.id", id)
+		w.Header().Set(trace.HEADER_REQUEST_ID, id)
+		w.Header().Set(trace.HEADER_TRACEPARENT, span.Context().Traceparent())
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if 0 == sw.status {
+			sw.status = http.StatusOK
+		}
+		span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(sw.status)))
+		}
+		span.End()
+	})
+}
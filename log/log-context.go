@@ -0,0 +1,24 @@
+package log
+
+import (
+	"context"
+	"github.com/service-kit/short-url/trace"
+	"go.uber.org/zap"
+)
+
+// FromContext returns the logger tagged with the request id and trace of ctx
+func FromContext(ctx context.Context) *zap.Logger {
+	l := GetInstance().GetLogger()
+	var fields []zap.Field
+	if id := trace.RequestID(ctx); "" != id {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if span := trace.FromContext(ctx); nil != span {
+		sc := span.Context()
+		fields = append(fields, zap.String("trace_id", sc.TraceIDString()), zap.String("span_id", sc.SpanIDString()))
+	}
+	if 0 == len(fields) {
+		return l
+	}
+	return l.With(fields...)
+}
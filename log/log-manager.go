@@ -6,11 +6,14 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"os"
 	"sync"
 )
 
 type LogManager struct {
 	logger *zap.Logger
+	level  zap.AtomicLevel
 }
 
 var m *LogManager
@@ -18,54 +21,89 @@ var once sync.Once
 
 func GetInstance() *LogManager {
 	once.Do(func() {
-		m = &LogManager{}
+		m = &LogManager{level: zap.NewAtomicLevel()}
 	})
 	return m
 }
 
+// defaultLogConfig is used when config failed to load, logging to stderr
+func defaultLogConfig() config.LogConfig {
+	return config.LogConfig{
+		Level:    common.LL_INFO,
+		Name:     "short-url",
+		Encoding: common.LE_JSON,
+	}
+}
+
 func (self *LogManager) InitManager() (err error) {
-	logPath, _ := config.GetInstance().GetConfig("LOG_FILE_PATH")
-	logLevel, _ := config.GetInstance().GetConfig("LOG_LEVEL")
-	if "" == logPath {
-		cfg := zap.NewProductionConfig()
-		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		self.logger, err = cfg.Build()
-		if nil != err {
-			return
+	logConf := defaultLogConfig()
+	if conf := config.GetInstance().Config(); nil != conf {
+		logConf = conf.Log
+	}
+	self.level.SetLevel(parseLevel(logConf.Level))
+	var syncers []zapcore.WriteSyncer
+	if "" != logConf.FilePath {
+		syncers = append(syncers, zapcore.AddSync(NewRotateWriter(logConf.FilePath, logConf)))
+	}
+	switch logConf.Console {
+	case common.LC_STDOUT:
+		syncers = append(syncers, zapcore.Lock(os.Stdout))
+	case common.LC_STDERR:
+		syncers = append(syncers, zapcore.Lock(os.Stderr))
+	default:
+		if 0 == len(syncers) {
+			syncers = append(syncers, zapcore.Lock(os.Stderr))
 		}
+	}
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	if common.LE_CONSOLE == logConf.Encoding {
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
 	} else {
-		hook := lumberjack.Logger{
-			Filename:   logPath, // 日志文件路径
-			MaxSize:    1024,    // megabytes
-			MaxBackups: 100,     // 最多保留3个备份
-			MaxAge:     30,      //days
-			Compress:   true,    // 是否压缩 disabled by default
-		}
-		writeSyncer := zapcore.AddSync(&hook)
-		var level zapcore.Level
-		switch logLevel {
-		case common.LL_DEBUG:
-			level = zap.DebugLevel
-		case common.LL_INFO:
-			level = zap.InfoLevel
-		case common.LL_ERROR:
-			level = zap.ErrorLevel
-		default:
-			level = zap.InfoLevel
-		}
-		encoderConfig := zap.NewProductionEncoderConfig()
-		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		core := zapcore.NewCore(
-			zapcore.NewConsoleEncoder(encoderConfig),
-			writeSyncer,
-			level,
-		)
-		self.logger = zap.New(core)
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
 	}
-	self.logger = self.logger.Named("asr-auth")
+	core := zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), self.level)
+	self.logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zap.ErrorLevel)).Named(logConf.Name)
+	config.GetInstance().Subscribe(self.onConfigChange)
 	return
 }
 
+// NewRotateWriter returns a writer to path rotated by the settings of logConf
+func NewRotateWriter(path string, logConf config.LogConfig) io.WriteCloser {
+	return &lumberjack.Logger{
+		Filename:   path,               // 日志文件路径
+		MaxSize:    logConf.MaxSize,    // megabytes
+		MaxBackups: logConf.MaxBackups, // 最多保留备份数量
+		MaxAge:     logConf.MaxAge,     //days
+		Compress:   logConf.Compress,   // 是否压缩
+	}
+}
+
+func parseLevel(logLevel string) zapcore.Level {
+	switch logLevel {
+	case common.LL_DEBUG:
+		return zap.DebugLevel
+	case common.LL_INFO:
+		return zap.InfoLevel
+	case common.LL_ERROR:
+		return zap.ErrorLevel
+	default:
+		return zap.InfoLevel
+	}
+}
+
+// onConfigChange only follows LOG_LEVEL edits, so a level set at runtime
+// through the admin endpoint survives reloads of unrelated keys
+func (self *LogManager) onConfigChange(change *config.ConfigChange) {
+	if change.Old.Log.Level == change.New.Log.Level {
+		return
+	}
+	level := parseLevel(change.New.Log.Level)
+	self.logger.Info("log level changed", zap.Stringer("level", level))
+	self.level.SetLevel(level)
+}
+
 func (self *LogManager) FinishProcess() {
 	if nil != self.logger {
 		self.logger.Sync()
@@ -75,3 +113,8 @@ func (self *LogManager) FinishProcess() {
 func (self *LogManager) GetLogger() *zap.Logger {
 	return self.logger
 }
+
+// GetLevel returns the level shared by every core, it serves GET/PUT as http.Handler
+func (self *LogManager) GetLevel() zap.AtomicLevel {
+	return self.level
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"github.com/service-kit/short-url/service"
+	"github.com/service-kit/short-url/cli"
+	"os"
 )
 
 func main() {
-	service.StartService()
+	os.Exit(cli.Run(os.Args[1:]))
 }
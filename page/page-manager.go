@@ -0,0 +1,184 @@
+// Package page renders the html pages. The templates are built into the
+// binary and parsed once; files in TEMPLATE_DIR replace the built-in ones of
+// the same name, so a theme only holds what it changes:
+//
+//	layout.html         defines "layout", wrapping every page
+//	partials/*.html     defines shared blocks like "header" and "footer"
+//	<page>.html         defines the "title" and "content" of one page
+//
+// DISABLED_HTML and BLOCKED_HTML may name standalone pages instead.
+package page
+
+import (
+	"bytes"
+	"errors"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/html"
+	"github.com/service-kit/short-url/log"
+	"go.uber.org/zap"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	PAGE_INDEX           = "index"
+	PAGE_PAYLOAD         = "payload"
+	PAGE_REGISTER_RESULT = "register_result"
+	PAGE_DISABLED        = "disabled"
+	PAGE_BLOCKED         = "blocked"
+	PAGE_ERROR           = "error"
+	PAGE_PREVIEW         = "preview"
+	PAGE_PASSWORD        = "password"
+)
+
+const (
+	LAYOUT_FILE  = "layout.html"
+	LAYOUT_NAME  = "layout"
+	PARTIALS_DIR = "partials"
+)
+
+// page is a parsed template and the template to execute of it
+type page struct {
+	t     *template.Template
+	entry string
+}
+
+type PageManager struct {
+	lock  sync.RWMutex
+	pages map[string]*page
+}
+
+var m *PageManager
+var once sync.Once
+var logger *zap.Logger
+
+func GetInstance() *PageManager {
+	once.Do(func() {
+		m = &PageManager{}
+	})
+	return m
+}
+
+func (self *PageManager) InitManager() error {
+	logger = log.GetInstance().GetLogger()
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	err := self.load(conf)
+	if nil != err {
+		return err
+	}
+	config.GetInstance().Subscribe(self.onConfigChange)
+	return nil
+}
+
+// onConfigChange re-parses the templates, a broken theme keeps the old pages
+func (self *PageManager) onConfigChange(change *config.ConfigChange) {
+	err := self.load(change.New)
+	if nil != err {
+		logger.Error("reload templates err, keep running templates", zap.Error(err))
+	}
+}
+
+// readTemplates collects the .html files of fsys by their slash path
+func readTemplates(fsys fs.FS, files map[string][]byte) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if nil != err || d.IsDir() || ".html" != path.Ext(name) {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if nil != err {
+			return err
+		}
+		files[name] = content
+		return nil
+	})
+}
+
+func (self *PageManager) load(conf *config.Config) error {
+	files := make(map[string][]byte)
+	err := readTemplates(html.Templates, files)
+	if nil != err {
+		return err
+	}
+	if "" != conf.TemplateDir {
+		err = readTemplates(os.DirFS(conf.TemplateDir), files)
+		if nil != err {
+			return errors.New("read template dir err: " + err.Error())
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	base := template.New(LAYOUT_FILE)
+	if _, err = base.Parse(string(files[LAYOUT_FILE])); nil != err {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, PARTIALS_DIR+"/") {
+			if _, err = base.New(name).Parse(string(files[name])); nil != err {
+				return err
+			}
+		}
+	}
+	pages := make(map[string]*page)
+	for _, name := range names {
+		if LAYOUT_FILE == name || strings.Contains(name, "/") {
+			continue
+		}
+		t, err := base.Clone()
+		if nil != err {
+			return err
+		}
+		if _, err = t.New(name).Parse(string(files[name])); nil != err {
+			return err
+		}
+		if nil == t.Lookup("content") {
+			return errors.New(name + " defines no content")
+		}
+		pages[strings.TrimSuffix(name, ".html")] = &page{t: t, entry: LAYOUT_NAME}
+	}
+	standalone := map[string]string{PAGE_DISABLED: conf.DisabledHtml, PAGE_BLOCKED: conf.BlockedHtml}
+	for name, file := range standalone {
+		if "" == file {
+			continue
+		}
+		t, err := template.ParseFiles(file)
+		if nil != err {
+			return err
+		}
+		pages[name] = &page{t: t, entry: t.Name()}
+	}
+	self.lock.Lock()
+	self.pages = pages
+	self.lock.Unlock()
+	logger.Info("templates loaded", zap.String("dir", conf.TemplateDir), zap.Int("pages", len(pages)))
+	return nil
+}
+
+// Render executes the page called name with data and writes it to w, nothing
+// is written when it fails
+func (self *PageManager) Render(w io.Writer, name string, data interface{}) error {
+	self.lock.RLock()
+	p := self.pages[name]
+	self.lock.RUnlock()
+	if nil == p {
+		return errors.New("page not found: " + name)
+	}
+	buf := new(bytes.Buffer)
+	err := p.t.ExecuteTemplate(buf, p.entry, data)
+	if nil != err {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
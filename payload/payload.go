@@ -0,0 +1,432 @@
+// Package payload builds the text of QR codes that are not links: WiFi
+// credentials, contact cards, calendar events, SMS and geo locations.
+package payload
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TYPE_WIFI   = "wifi"
+	TYPE_MECARD = "mecard"
+	TYPE_VCARD  = "vcard"
+	TYPE_EVENT  = "event"
+	TYPE_SMS    = "sms"
+	TYPE_GEO    = "geo"
+)
+
+const (
+	WIFI_WPA    = "WPA"
+	WIFI_WEP    = "WEP"
+	WIFI_NOPASS = "nopass"
+)
+
+// MAX_FIELD_LEN bounds each field, a QR code holds under 3KB in total
+const MAX_FIELD_LEN = 1024
+
+// fields lists the form fields read for each type
+var fields = map[string][]string{
+	TYPE_WIFI:   {"ssid", "password", "auth", "hidden"},
+	TYPE_MECARD: contactFields,
+	TYPE_VCARD:  contactFields,
+	TYPE_EVENT:  {"summary", "location", "description", "start", "end"},
+	TYPE_SMS:    {"phone", "message"},
+	TYPE_GEO:    {"lat", "lon", "label"},
+}
+
+var contactFields = []string{"first_name", "last_name", "org", "title", "phone", "email", "url", "address", "note"}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{2,31}$`)
+
+// Payload is a built payload. Text is what the QR code encodes; contacts and
+// events can also be downloaded as File, sms and geo opened as Uri
+type Payload struct {
+	Type        string
+	Text        string
+	File        string
+	FileName    string
+	ContentType string
+	Uri         string
+	// Query holds the fields Payload was built from, to build it again
+	Query url.Values
+}
+
+// Openable reports whether a browser can open the payload, which a short
+// link wrapping it needs
+func (self *Payload) Openable() bool {
+	return "" != self.File || "" != self.Uri
+}
+
+// Build builds a payload of type kind from the form fields of that type
+func Build(kind string, form url.Values) (*Payload, error) {
+	names, ok := fields[kind]
+	if !ok {
+		return nil, errors.New("unknown payload type: " + kind)
+	}
+	query := url.Values{"type": {kind}}
+	for _, name := range names {
+		value := strings.TrimSpace(form.Get(name))
+		if len(value) > MAX_FIELD_LEN {
+			return nil, errors.New(name + " is too long")
+		}
+		if "" != value {
+			query.Set(name, value)
+		}
+	}
+	p := &Payload{Type: kind, Query: query}
+	var err error
+	switch kind {
+	case TYPE_WIFI:
+		p.Text, err = Wifi(query.Get("auth"), query.Get("ssid"), query.Get("password"), "1" == query.Get("hidden") || "true" == query.Get("hidden"))
+	case TYPE_MECARD, TYPE_VCARD:
+		contact := Contact{
+			FirstName: query.Get("first_name"),
+			LastName:  query.Get("last_name"),
+			Org:       query.Get("org"),
+			Title:     query.Get("title"),
+			Phone:     query.Get("phone"),
+			Email:     query.Get("email"),
+			Url:       query.Get("url"),
+			Address:   query.Get("address"),
+			Note:      query.Get("note"),
+		}
+		err = contact.check()
+		if nil != err {
+			return nil, err
+		}
+		p.File, p.FileName, p.ContentType = contact.VCard(), "contact.vcf", "text/vcard; charset=utf-8"
+		p.Text = p.File
+		if TYPE_MECARD == kind {
+			p.Text = contact.Mecard()
+		}
+	case TYPE_EVENT:
+		var event Event
+		event, err = parseEvent(query)
+		if nil != err {
+			return nil, err
+		}
+		p.Text = event.VEvent()
+		p.File, p.FileName, p.ContentType = event.Calendar(), "event.ics", "text/calendar; charset=utf-8"
+	case TYPE_SMS:
+		p.Text, p.Uri, err = Sms(query.Get("phone"), query.Get("message"))
+	case TYPE_GEO:
+		var lat, lon float64
+		lat, lon, err = parseLatLon(query.Get("lat"), query.Get("lon"))
+		if nil != err {
+			return nil, err
+		}
+		p.Text, err = Geo(lat, lon, query.Get("label"))
+		p.Uri = p.Text
+	}
+	if nil != err {
+		return nil, err
+	}
+	return p, nil
+}
+
+// escape backslash-escapes the characters in special
+func escape(str, special string) string {
+	var b strings.Builder
+	for _, c := range str {
+		if strings.ContainsRune(special, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Wifi builds a WIFI: payload, auth defaults to WPA with a password and
+// nopass without
+func Wifi(auth, ssid, password string, hidden bool) (string, error) {
+	if "" == ssid {
+		return "", errors.New("ssid is required")
+	}
+	if "" == auth {
+		auth = WIFI_NOPASS
+		if "" != password {
+			auth = WIFI_WPA
+		}
+	}
+	switch strings.ToUpper(auth) {
+	case WIFI_WPA, "WPA2":
+		auth = WIFI_WPA
+	case WIFI_WEP:
+		auth = WIFI_WEP
+	case strings.ToUpper(WIFI_NOPASS):
+		auth = WIFI_NOPASS
+	default:
+		return "", errors.New("invalid auth: " + auth)
+	}
+	if WIFI_NOPASS != auth && "" == password {
+		return "", errors.New("password is required for " + auth)
+	}
+	const special = `\;,:"`
+	text := "WIFI:T:" + auth + ";S:" + escape(ssid, special) + ";"
+	if WIFI_NOPASS != auth {
+		text += "P:" + escape(password, special) + ";"
+	}
+	if hidden {
+		text += "H:true;"
+	}
+	return text + ";", nil
+}
+
+// Contact is a contact card, encoded as MECARD or vCard 3.0
+type Contact struct {
+	FirstName string
+	LastName  string
+	Org       string
+	Title     string
+	Phone     string
+	Email     string
+	Url       string
+	Address   string
+	Note      string
+}
+
+func (self *Contact) check() error {
+	if "" == self.FirstName && "" == self.LastName && "" == self.Org {
+		return errors.New("a name or org is required")
+	}
+	if "" != self.Phone && !phonePattern.MatchString(self.Phone) {
+		return errors.New("invalid phone: " + self.Phone)
+	}
+	if "" != self.Email && !strings.Contains(self.Email, "@") {
+		return errors.New("invalid email: " + self.Email)
+	}
+	return nil
+}
+
+func (self *Contact) fullName() string {
+	return strings.TrimSpace(self.FirstName + " " + self.LastName)
+}
+
+// Mecard is the compact format most phone cameras read
+func (self *Contact) Mecard() string {
+	const special = `\;,:`
+	var b strings.Builder
+	b.WriteString("MECARD:")
+	field := func(name, value string) {
+		if "" != value {
+			b.WriteString(name + ":" + escape(value, special) + ";")
+		}
+	}
+	// N is last,first, the separating comma is not escaped
+	name := escape(self.LastName, special)
+	if "" != self.LastName && "" != self.FirstName {
+		name += ","
+	}
+	name += escape(self.FirstName, special)
+	if "" == name {
+		name = escape(self.Org, special)
+	}
+	b.WriteString("N:" + name + ";")
+	field("ORG", self.Org)
+	field("TITLE", self.Title)
+	field("TEL", self.Phone)
+	field("EMAIL", self.Email)
+	field("URL", self.Url)
+	field("ADR", self.Address)
+	field("NOTE", self.Note)
+	b.WriteString(";")
+	return b.String()
+}
+
+// textEscape escapes a TEXT value of vCard and iCalendar
+func textEscape(str string) string {
+	str = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(str)
+	return strings.Replace(escape(str, `\;,`), "\n", `\n`, -1)
+}
+
+// VCard is the vCard 3.0 form, also served as a .vcf file
+func (self *Contact) VCard() string {
+	lines := []string{"BEGIN:VCARD", "VERSION:3.0"}
+	fn := self.fullName()
+	if "" == fn {
+		fn = self.Org
+	}
+	lines = append(lines, "N:"+textEscape(self.LastName)+";"+textEscape(self.FirstName)+";;;", "FN:"+textEscape(fn))
+	add := func(name, value string) {
+		if "" != value {
+			lines = append(lines, name+":"+textEscape(value))
+		}
+	}
+	add("ORG", self.Org)
+	add("TITLE", self.Title)
+	add("TEL", self.Phone)
+	add("EMAIL", self.Email)
+	add("URL", self.Url)
+	if "" != self.Address {
+		// the free form address goes into the street component
+		lines = append(lines, "ADR:;;"+textEscape(self.Address)+";;;;")
+	}
+	add("NOTE", self.Note)
+	lines = append(lines, "END:VCARD")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// Event is a calendar event. Times are in UTC unless Floating, which keeps
+// the wall clock time of whoever adds the event; AllDay events use dates
+type Event struct {
+	Summary     string
+	Location    string
+	Description string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Floating    bool
+}
+
+const (
+	eventDate     = "2006-01-02"
+	eventLocal    = "2006-01-02T15:04"
+	eventLocalSec = "2006-01-02T15:04:05"
+)
+
+// parseEventTime accepts RFC 3339, a local date time as sent by a
+// datetime-local input, or a date for all day events
+func parseEventTime(str string) (t time.Time, allDay, floating bool, err error) {
+	if t, err = time.Parse(time.RFC3339, str); nil == err {
+		return t.UTC(), false, false, nil
+	}
+	for _, layout := range []string{eventLocal, eventLocalSec} {
+		if t, err = time.Parse(layout, str); nil == err {
+			return t, false, true, nil
+		}
+	}
+	if t, err = time.Parse(eventDate, str); nil == err {
+		return t, true, false, nil
+	}
+	return t, false, false, errors.New("invalid time: " + str)
+}
+
+func parseEvent(query url.Values) (Event, error) {
+	event := Event{Summary: query.Get("summary"), Location: query.Get("location"), Description: query.Get("description")}
+	if "" == event.Summary {
+		return event, errors.New("summary is required")
+	}
+	if "" == query.Get("start") {
+		return event, errors.New("start is required")
+	}
+	var err error
+	event.Start, event.AllDay, event.Floating, err = parseEventTime(query.Get("start"))
+	if nil != err {
+		return event, err
+	}
+	if "" == query.Get("end") {
+		if event.AllDay {
+			event.End = event.Start.AddDate(0, 0, 1)
+		} else {
+			event.End = event.Start.Add(time.Hour)
+		}
+		return event, nil
+	}
+	end, allDay, floating, err := parseEventTime(query.Get("end"))
+	if nil != err {
+		return event, err
+	}
+	if allDay != event.AllDay || floating != event.Floating {
+		return event, errors.New("start and end must have the same form")
+	}
+	if allDay {
+		// the end date of an all day event is exclusive
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(event.Start) {
+		return event, errors.New("end must be after start")
+	}
+	event.End = end
+	return event, nil
+}
+
+func (self *Event) formatTime(name string, t time.Time) string {
+	if self.AllDay {
+		return name + ";VALUE=DATE:" + t.Format("20060102")
+	}
+	if self.Floating {
+		return name + ":" + t.Format("20060102T150405")
+	}
+	return name + ":" + t.UTC().Format("20060102T150405Z")
+}
+
+func (self *Event) lines() []string {
+	lines := []string{"BEGIN:VEVENT", "SUMMARY:" + textEscape(self.Summary), self.formatTime("DTSTART", self.Start), self.formatTime("DTEND", self.End)}
+	if "" != self.Location {
+		lines = append(lines, "LOCATION:"+textEscape(self.Location))
+	}
+	if "" != self.Description {
+		lines = append(lines, "DESCRIPTION:"+textEscape(self.Description))
+	}
+	return append(lines, "END:VEVENT")
+}
+
+// VEvent is the bare VEVENT scanners expect in a QR code
+func (self *Event) VEvent() string {
+	return strings.Join(self.lines(), "\r\n") + "\r\n"
+}
+
+// Calendar wraps the event in a VCALENDAR as calendar apps import it
+func (self *Event) Calendar() string {
+	lines := self.lines()
+	// a UID derived from the event makes a second import update the first
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(lines, "\n")))
+	uid := strconv.FormatUint(h.Sum64(), 36) + "@short-url"
+	lines = append(lines[:len(lines)-1], "UID:"+uid, "DTSTAMP:"+time.Now().UTC().Format("20060102T150405Z"), "END:VEVENT")
+	lines = append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//service-kit//short-url//EN"}, lines...)
+	lines = append(lines, "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// uriEscape escapes a query value with %20 for spaces, as sms and map apps
+// take a + literally
+func uriEscape(str string) string {
+	return strings.Replace(url.QueryEscape(str), "+", "%20", -1)
+}
+
+// Sms builds an SMSTO: payload and the sms: uri opening the same message
+func Sms(phone, message string) (text, uri string, err error) {
+	if !phonePattern.MatchString(phone) {
+		return "", "", errors.New("invalid phone: " + phone)
+	}
+	number := strings.NewReplacer(" ", "", "(", "", ")", "", "-", "").Replace(phone)
+	uri = "sms:" + number
+	if "" != message {
+		uri += "?body=" + uriEscape(message)
+	}
+	return "SMSTO:" + number + ":" + message, uri, nil
+}
+
+func parseLatLon(latStr, lonStr string) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(latStr, 64)
+	if nil != err || lat < -90 || lat > 90 {
+		return 0, 0, errors.New("invalid lat: " + latStr)
+	}
+	lon, err := strconv.ParseFloat(lonStr, 64)
+	if nil != err || lon < -180 || lon > 180 {
+		return 0, 0, errors.New("invalid lon: " + lonStr)
+	}
+	return lat, lon, nil
+}
+
+// Geo builds a geo: uri, label is shown by map apps that support ?q=
+func Geo(lat, lon float64, label string) (string, error) {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return "", errors.New("coordinates out of range")
+	}
+	format := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	text := "geo:" + format(lat) + "," + format(lon)
+	if "" != label {
+		text += "?q=" + format(lat) + "," + format(lon) + "(" + uriEscape(label) + ")"
+	}
+	return text, nil
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/util"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// isServicePage reports whether path is a page of this service rather than
+// a short link, like the open page of wrapped payloads; dot segments are
+// resolved first so /static/../code is no way around the chain check
+func isServicePage(p string) bool {
+	p = path.Clean(p)
+	return common.PAYLOAD_PATH == p || common.PAYLOAD_OPEN_PATH == p ||
+		strings.HasPrefix(p, common.STATIC_PATH_PREFIX)
+}
+
+// selfCode returns the code a normalized url names on SHORT_URL_HEADER, ok
+// is false for urls on other hosts and for the pages of this service
+func selfCode(u *url.URL, header string) (string, bool) {
+	h, err := url.Parse(header)
+	if nil != err || "" == h.Host {
+		return "", false
+	}
+	host, err := hostToASCII(h.Hostname())
+	if nil != err {
+		return "", false
+	}
+	if port := h.Port(); "" != port && defaultPorts[strings.ToLower(h.Scheme)] != port {
+		host += ":" + port
+	}
+	if host != u.Host || isServicePage(u.Path) {
+		return "", false
+	}
+	prefix := h.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(u.Path, prefix) {
+		return "", true
+	}
+	return u.Path[len(prefix):], true
+}
+
+// isShortener reports whether host is one of the shorteners or below one
+func isShortener(shorteners []string, host string) bool {
+	for _, domain := range shorteners {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveChain rejects normalized urls pointing at other shorteners, and
+// urls pointing back at this service unless URL_CHAIN is flatten, which
+// replaces them by the original url of the named link, up to URL_CHAIN_DEPTH
+// links deep
+func (self *PolicyManager) resolveChain(ctx context.Context, normalized string) (string, error) {
+	conf := self.getConfig()
+	for depth := 0; ; depth++ {
+		u, err := url.Parse(normalized)
+		if nil != err {
+			return "", err
+		}
+		if isShortener(conf.Url.Shorteners, u.Hostname()) {
+			return "", errors.New("links to other url shorteners are not allowed")
+		}
+		code, ok := selfCode(u, conf.ShortUrlHeader)
+		if !ok {
+			return normalized, nil
+		}
+		if common.CH_FLATTEN != conf.Url.Chain || "" == code || strings.Contains(code, "/") {
+			return "", errors.New("links back to this service are not allowed")
+		}
+		if depth == conf.Url.ChainDepth {
+			return "", errors.New("more than " + strconv.Itoa(conf.Url.ChainDepth) + " short urls chained")
+		}
+		info, err := data.GetInstance().GetShortUrlInfo(ctx, code)
+		if nil != err || "" == info.OriginalUrl {
+			return "", errors.New("short url " + code + " does not exist")
+		}
+		if common.LS_ACTIVE != info.Status || info.IsExpired(util.GetCurrentSeconds()) {
+			return "", errors.New("short url " + code + " is not active")
+		}
+		// flattening would skip the password
+		if "" != info.PasswordHash {
+			return "", errors.New("short url " + code + " is password protected")
+		}
+		// imported links were not normalized
+		normalized, err = self.Normalize(info.OriginalUrl)
+		if nil != err {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"context"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func chainManager(chain string) *PolicyManager {
+	m := &PolicyManager{}
+	m.conf.Store(&config.Config{
+		ShortUrlHeader: "http://s.io/",
+		Url: config.UrlConfig{
+			Schemes:    []string{"http", "https"},
+			MaxLength:  2048,
+			Shorteners: strings.Split(common.SHORTENERS, ","),
+			Chain:      chain,
+			ChainDepth: 3,
+		},
+	})
+	m.rules.Store(&ruleSet{})
+	return m
+}
+
+// TestChainLetsWrappedPayloadsThrough covers /api/qr/payload?wrap=1, which
+// shortens the open page of the payload on this service
+func TestChainLetsWrappedPayloadsThrough(t *testing.T) {
+	query := url.Values{"type": {"sms"}, "phone": {"+15551234"}, "body": {"hi there"}}
+	// built as wrapPayload builds it
+	wrapped := "http://s.io/" + strings.TrimPrefix(common.PAYLOAD_OPEN_PATH, "/") + "?" + query.Encode()
+	allowed := []string{
+		wrapped,
+		"http://S.IO/qr/payload?type=wifi",
+		"http://s.io/static/menu.pdf",
+		"https://example.com/qr/payload/open",
+	}
+	for _, chain := range []string{common.CH_REJECT, common.CH_FLATTEN} {
+		m := chainManager(chain)
+		for _, raw := range allowed {
+			normalized, err := m.Normalize(raw)
+			if nil != err {
+				t.Fatalf("Normalize(%q): %v", raw, err)
+			}
+			got, err := m.resolveChain(context.Background(), normalized)
+			if nil != err {
+				t.Errorf("%s: resolveChain(%q): %v", chain, normalized, err)
+				continue
+			}
+			if normalized != got {
+				t.Errorf("%s: resolveChain(%q) = %q", chain, normalized, got)
+			}
+		}
+	}
+}
+
+func TestChainRejectsLinksBack(t *testing.T) {
+	rejected := []string{
+		"http://s.io/abc123",
+		"http://s.io/abc123/qr",
+		"http://s.io/static/../abc123",
+		"http://s.io/static/%2e%2e/abc123",
+		"http://s.io/qr/payload/open/../../abc123",
+		"https://bit.ly/xyz",
+	}
+	m := chainManager(common.CH_REJECT)
+	for _, raw := range rejected {
+		normalized, err := m.Normalize(raw)
+		if nil != err {
+			t.Fatalf("Normalize(%q): %v", raw, err)
+		}
+		if got, err := m.resolveChain(context.Background(), normalized); nil == err {
+			t.Errorf("resolveChain(%q) = %q, want an error", normalized, got)
+		}
+	}
+}
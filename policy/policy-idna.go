@@ -0,0 +1,52 @@
+package policy
+
+import (
+	"errors"
+	"golang.org/x/net/idna"
+	"strings"
+)
+
+const (
+	MAX_LABEL_LEN = 63
+	MAX_HOST_LEN  = 253
+)
+
+// hostProfile is idna.Lookup, UTS #46 mapping with NFC and the bidi and
+// joiner rules, letting through the underscores and double hyphens of real
+// world hosts as browsers do; labels are checked by hostToASCII
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.CheckJoiners(true),
+	idna.StrictDomainName(false), idna.CheckHyphens(false))
+
+func isLDH(label string) bool {
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || '-' == c || '_' == c) {
+			return false
+		}
+	}
+	return true
+}
+
+// hostToASCII maps host as a browser looking it up would, fullwidth letters
+// and dots and upper case fold, text is NFC normalized and unicode labels
+// become punycode; a trailing dot is dropped
+func hostToASCII(host string) (string, error) {
+	ascii, err := hostProfile.ToASCII(host)
+	if nil != err {
+		return "", errors.New("invalid host " + host)
+	}
+	ascii = strings.TrimSuffix(ascii, ".")
+	if "" == ascii {
+		return "", errors.New("url has no host")
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if "" == label || !isLDH(label) || len(label) > MAX_LABEL_LEN ||
+			strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", errors.New("invalid host " + host)
+		}
+	}
+	if len(ascii) > MAX_HOST_LEN {
+		return "", errors.New("host too long")
+	}
+	return ascii, nil
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"github.com/service-kit/short-url/config"
+	"testing"
+)
+
+func TestHostToASCII(t *testing.T) {
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"Example.COM.", "example.com"},
+		// fullwidth letters and the ideographic full stop map to ascii
+		{"ｅｖｉｌ.com", "evil.com"},
+		{"ＥＶＩＬ。ｃｏｍ", "evil.com"},
+		{"evil．com", "evil.com"},
+		// nontransitional, ß stays a letter of its own as browsers do it
+		{"straße.de", "xn--strae-oqa.de"},
+		{"STRASSE.de", "strasse.de"},
+		// NFC, a combining accent equals the precomposed letter
+		{"café.fr", "xn--caf-dma.fr"},
+		{"café.fr", "xn--caf-dma.fr"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		// confusables are distinct hosts, cyrillic а is not latin a
+		{"аpple.com", "xn--pple-43d.com"},
+		{"раypal.com", "xn--ypal-43d9g.com"},
+		// soft hyphens and other ignored code points vanish
+		{"ev­il.com", "evil.com"},
+		{"my_host.example.com", "my_host.example.com"},
+		{"r3---sn-abc.googlevideo.com", "r3---sn-abc.googlevideo.com"},
+	}
+	for _, c := range cases {
+		got, err := hostToASCII(c.host)
+		if nil != err {
+			t.Errorf("hostToASCII(%q): %v", c.host, err)
+			continue
+		}
+		if c.want != got {
+			t.Errorf("hostToASCII(%q) = %q, want %q", c.host, got, c.want)
+		}
+	}
+}
+
+func TestHostToASCIIInvalid(t *testing.T) {
+	hosts := []string{
+		"",
+		".",
+		"a..b",
+		"-evil.com",
+		"evil-.com",
+		"ev!l.com",
+		"xn--a.com",
+		// a right to left label mixed with a left to right one
+		"aא.com",
+		"a‍b.com",
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.com",
+	}
+	for _, host := range hosts {
+		if got, err := hostToASCII(host); nil == err {
+			t.Errorf("hostToASCII(%q) = %q, want an error", host, got)
+		}
+	}
+}
+
+// TestMappedHostsMatchRules makes sure a block rule can not be dodged by
+// writing the host in other forms
+func TestMappedHostsMatchRules(t *testing.T) {
+	m := &PolicyManager{}
+	m.conf.Store(&config.Config{Url: config.UrlConfig{Schemes: []string{"http", "https"}, MaxLength: 2048}})
+	block := &ruleList{domains: make(map[string]bool)}
+	for _, line := range []string{"evil.com", "ｂａｄ.org", "straße.de"} {
+		if err := block.add(line); nil != err {
+			t.Fatalf("add %q: %v", line, err)
+		}
+	}
+	m.rules.Store(&ruleSet{block: block})
+	blocked := []string{
+		"https://ｅｖｉｌ.com/x",
+		"https://EVIL。com/x",
+		"https://www.ev­il.com/",
+		"http://bad.org/",
+		"https://STRAẞE.de/",
+	}
+	for _, raw := range blocked {
+		normalized, err := m.Normalize(raw)
+		if nil != err {
+			t.Errorf("Normalize(%q): %v", raw, err)
+			continue
+		}
+		if "" == m.Blocked(normalized) {
+			t.Errorf("%q normalized to %q is not blocked", raw, normalized)
+		}
+	}
+	allowed := []string{"https://еvil.com/", "https://strasse.de/"}
+	for _, raw := range allowed {
+		normalized, err := m.Normalize(raw)
+		if nil != err {
+			t.Errorf("Normalize(%q): %v", raw, err)
+			continue
+		}
+		if reason := m.Blocked(normalized); "" != reason {
+			t.Errorf("%q normalized to %q is blocked: %s", raw, normalized, reason)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package policy decides which original urls may be shortened and brings
+// them into one canonical form, so equal urls always map to one code. The
+// allow and block lists of URL_ALLOW_FILE and URL_BLOCK_FILE are applied on
+// create and again on every redirect.
+package policy
+
+import (
+	"context"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+type PolicyManager struct {
+	conf       atomic.Value
+	rules      atomic.Value
+	reloadLock sync.Mutex
+}
+
+// ruleSet is replaced as a whole on reload
+type ruleSet struct {
+	allow   *ruleList
+	block   *ruleList
+	trusted *ruleList
+}
+
+var m *PolicyManager
+var once sync.Once
+var logger *zap.Logger
+
+func GetInstance() *PolicyManager {
+	once.Do(func() {
+		m = &PolicyManager{}
+	})
+	return m
+}
+
+func (self *PolicyManager) InitManager() error {
+	logger = log.GetInstance().GetLogger()
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	self.conf.Store(conf)
+	err := self.loadRules(&conf.Url)
+	if nil != err {
+		return err
+	}
+	config.GetInstance().Subscribe(self.onConfigChange)
+	data.GetInstance().AddUrlCheck(self.Check)
+	return nil
+}
+
+func (self *PolicyManager) onConfigChange(change *config.ConfigChange) {
+	self.conf.Store(change.New)
+	err := self.loadRules(&change.New.Url)
+	if nil != err {
+		logger.Error("reload url rules err, keep running rules", zap.Error(err))
+	}
+}
+
+func (self *PolicyManager) getConfig() *config.Config {
+	return self.conf.Load().(*config.Config)
+}
+
+func (self *PolicyManager) getRules() *ruleSet {
+	return self.rules.Load().(*ruleSet)
+}
+
+// loadRules reads both rule files, a bad file keeps the running rules
+func (self *PolicyManager) loadRules(conf *config.UrlConfig) error {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+	allow, err := loadRules(conf.AllowFile)
+	if nil != err {
+		return err
+	}
+	block, err := loadRules(conf.BlockFile)
+	if nil != err {
+		return err
+	}
+	trusted, err := loadRules(conf.TrustedFile)
+	if nil != err {
+		return err
+	}
+	self.rules.Store(&ruleSet{allow: allow, block: block, trusted: trusted})
+	if nil != allow || nil != block || nil != trusted {
+		logger.Info("url rules loaded", zap.String("allow file", conf.AllowFile), zap.String("block file", conf.BlockFile), zap.String("trusted file", conf.TrustedFile))
+	}
+	return nil
+}
+
+// Watch polls the rule files and reloads them on change
+func (self *PolicyManager) Watch(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			self.reloadLock.Lock()
+			rules := self.getRules()
+			changed := rules.allow.changed()
+			changed = rules.block.changed() || changed
+			changed = rules.trusted.changed() || changed
+			self.reloadLock.Unlock()
+			if !changed {
+				continue
+			}
+			err := self.loadRules(&self.getConfig().Url)
+			if nil != err {
+				logger.Error("reload url rules err, keep running rules", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// Trusted reports whether original_url matches the trusted rules
+func (self *PolicyManager) Trusted(original_url string) bool {
+	_, ok := self.getRules().trusted.match(hostOf(original_url), original_url)
+	return ok
+}
+
+func hostOf(original_url string) string {
+	u, err := url.Parse(original_url)
+	if nil != err {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// Blocked returns why the rules forbid original_url, empty if they allow it
+func (self *PolicyManager) Blocked(original_url string) string {
+	rules := self.getRules()
+	if rules.allow.empty() && rules.block.empty() {
+		return ""
+	}
+	host := hostOf(original_url)
+	if rule, ok := rules.block.match(host, original_url); ok {
+		return "destination blocked by rule " + rule
+	}
+	if _, ok := rules.allow.match(host, original_url); !rules.allow.empty() && !ok {
+		return "destination is not on the allow list"
+	}
+	return ""
+}
+
+// Check returns the canonical form of original_url, or why it may not be
+// shortened
+func (self *PolicyManager) Check(ctx context.Context, original_url string) (string, error) {
+	normalized, err := self.Normalize(original_url)
+	if nil == err {
+		normalized, err = self.resolveChain(ctx, normalized)
+	}
+	if nil != err {
+		log.FromContext(ctx).Info("url rejected", zap.String("original url", original_url), zap.Error(err))
+		return "", err
+	}
+	if reason := self.Blocked(normalized); "" != reason {
+		log.FromContext(ctx).Warn("url blocked", zap.String("original url", normalized), zap.String("reason", reason))
+		return "", errors.New(reason)
+	}
+	return normalized, nil
+}
+
+// Normalize validates raw against the scheme allow-list and length limit
+// and returns it with a lower-cased scheme and host, the host in punycode,
+// the default port dropped and the path slash policy applied
+func (self *PolicyManager) Normalize(raw string) (string, error) {
+	conf := &self.getConfig().Url
+	str := strings.TrimSpace(raw)
+	if "" == str {
+		return "", errors.New("url is empty")
+	}
+	if len(str) > conf.MaxLength {
+		return "", errors.New("url is longer than " + strconv.Itoa(conf.MaxLength))
+	}
+	for _, r := range str {
+		if r <= ' ' || 0x7f == r {
+			return "", errors.New("url contains whitespace or control characters")
+		}
+	}
+	u, err := url.Parse(str)
+	if nil != err {
+		return "", errors.New("invalid url: " + err.Error())
+	}
+	if "" == u.Scheme {
+		return "", errors.New("url must be absolute")
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !allowedScheme(conf.Schemes, u.Scheme) {
+		return "", errors.New("scheme " + u.Scheme + " is not allowed")
+	}
+	// mailto: and alike have no host to normalize
+	if "" != u.Opaque {
+		return u.String(), nil
+	}
+	if nil != u.User {
+		return "", errors.New("urls with credentials are not allowed")
+	}
+	host, port := u.Hostname(), u.Port()
+	if strings.HasPrefix(u.Host, "[") {
+		if nil == net.ParseIP(host) {
+			return "", errors.New("invalid host " + host)
+		}
+		host = "[" + strings.ToLower(host) + "]"
+	} else if host, err = hostToASCII(host); nil != err {
+		return "", err
+	}
+	if "" != port {
+		n, err := strconv.Atoi(port)
+		if nil != err || n < 1 || n > 65535 {
+			return "", errors.New("invalid port " + port)
+		}
+		if defaultPorts[u.Scheme] != port {
+			host += ":" + strconv.Itoa(n)
+		}
+	}
+	u.Host = host
+	applySlashPolicy(u, conf.TrailingSlash)
+	normalized := u.String()
+	if len(normalized) > conf.MaxLength {
+		return "", errors.New("url is longer than " + strconv.Itoa(conf.MaxLength))
+	}
+	return normalized, nil
+}
+
+func allowedScheme(schemes []string, scheme string) bool {
+	for _, s := range schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// applySlashPolicy turns an empty path into /, and adds or strips the slash
+// ending other paths; add leaves paths ending in a file name alone
+func applySlashPolicy(u *url.URL, policy string) {
+	if "" == u.Path {
+		u.Path, u.RawPath = "/", ""
+		return
+	}
+	if "/" == u.Path {
+		return
+	}
+	switch policy {
+	case common.TS_ADD:
+		last := u.Path[strings.LastIndex(u.Path, "/")+1:]
+		if "" != last && !strings.Contains(last, ".") {
+			u.Path += "/"
+			if "" != u.RawPath {
+				u.RawPath += "/"
+			}
+		}
+	case common.TS_STRIP:
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if "" == u.Path {
+			u.Path, u.RawPath = "/", ""
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleList holds the destination rules of one file
+type ruleList struct {
+	path    string
+	modTime int64
+	// domains match themselves and their subdomains
+	domains map[string]bool
+	globs   []rule
+	regexps []rule
+}
+
+type rule struct {
+	text    string
+	pattern *regexp.Regexp
+}
+
+func (self *ruleList) empty() bool {
+	return nil == self || 0 == len(self.domains)+len(self.globs)+len(self.regexps)
+}
+
+// match returns the rule matching host or url, host is lower-case ascii
+func (self *ruleList) match(host, url string) (string, bool) {
+	if nil == self {
+		return "", false
+	}
+	if "" != host {
+		for domain := host; ; {
+			if self.domains[domain] {
+				return domain, true
+			}
+			i := strings.IndexByte(domain, '.')
+			if i < 0 {
+				break
+			}
+			domain = domain[i+1:]
+		}
+		for _, r := range self.globs {
+			if r.pattern.MatchString(host) {
+				return r.text, true
+			}
+		}
+	}
+	for _, r := range self.regexps {
+		if r.pattern.MatchString(url) {
+			return r.text, true
+		}
+	}
+	return "", false
+}
+
+// globPattern turns a host wildcard into an anchored regexp, * spans dots
+func globPattern(glob string) (*regexp.Regexp, error) {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// loadRules reads one rule per line, blank lines and # comments are skipped:
+//
+//	example.com      example.com and any subdomain
+//	*.example.*      a wildcard over the host
+//	/^https?://[^/]+/login/   a regexp over the whole url
+func loadRules(path string) (*ruleList, error) {
+	if "" == path {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if nil != err {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if nil != err {
+		return nil, err
+	}
+	list := &ruleList{path: path, modTime: fi.ModTime().UnixNano(), domains: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if "" == line || strings.HasPrefix(line, "#") {
+			continue
+		}
+		err = list.add(line)
+		if nil != err {
+			return nil, errors.New(path + ":" + strconv.Itoa(n) + ": " + err.Error())
+		}
+	}
+	if err = scanner.Err(); nil != err {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (self *ruleList) add(line string) error {
+	if len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+		pattern, err := regexp.Compile(line[1 : len(line)-1])
+		if nil != err {
+			return err
+		}
+		self.regexps = append(self.regexps, rule{text: line, pattern: pattern})
+		return nil
+	}
+	if strings.Contains(line, "*") {
+		pattern, err := globPattern(strings.ToLower(line))
+		if nil != err {
+			return err
+		}
+		self.globs = append(self.globs, rule{text: line, pattern: pattern})
+		return nil
+	}
+	domain, err := hostToASCII(line)
+	if nil != err {
+		return err
+	}
+	self.domains[domain] = true
+	return nil
+}
+
+// changed reports whether the file of the list was modified since loaded
+// or since a failed reload, which is remembered so it is not re-read every tick
+func (self *ruleList) changed() bool {
+	if nil == self {
+		return false
+	}
+	var modTime int64
+	if fi, err := os.Stat(self.path); nil == err {
+		modTime = fi.ModTime().UnixNano()
+	}
+	if modTime == self.modTime {
+		return false
+	}
+	self.modTime = modTime
+	return true
+}
@@ -0,0 +1,77 @@
+package qrcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry struct {
+	code string
+	key  string
+	data []byte
+}
+
+// lru is the memory tier, bounded by the total size of the cached images
+type lru struct {
+	lock     sync.Mutex
+	maxBytes int64
+	bytes    int64
+	order    *list.List
+	// entries indexes by code then key, so a code drops all its renderings
+	entries map[string]map[string]*list.Element
+}
+
+func newLru(maxBytes int64) *lru {
+	return &lru{maxBytes: maxBytes, order: list.New(), entries: make(map[string]map[string]*list.Element)}
+}
+
+func (self *lru) get(code, key string) ([]byte, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	elem, ok := self.entries[code][key]
+	if !ok {
+		return nil, false
+	}
+	self.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).data, true
+}
+
+func (self *lru) set(code, key string, data []byte) {
+	size := int64(len(data))
+	if size > self.maxBytes {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if elem, ok := self.entries[code][key]; ok {
+		self.removeElement(elem)
+	}
+	keys := self.entries[code]
+	if nil == keys {
+		keys = make(map[string]*list.Element)
+		self.entries[code] = keys
+	}
+	keys[key] = self.order.PushFront(&lruEntry{code: code, key: key, data: data})
+	self.bytes += size
+	for self.bytes > self.maxBytes {
+		self.removeElement(self.order.Back())
+	}
+}
+
+func (self *lru) removeElement(elem *list.Element) {
+	entry := self.order.Remove(elem).(*lruEntry)
+	self.bytes -= int64(len(entry.data))
+	keys := self.entries[entry.code]
+	delete(keys, entry.key)
+	if 0 == len(keys) {
+		delete(self.entries, entry.code)
+	}
+}
+
+func (self *lru) invalidate(code string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, elem := range self.entries[code] {
+		self.removeElement(elem)
+	}
+}
@@ -0,0 +1,95 @@
+// Package qrcache caches rendered QR codes by link code and render options,
+// in a bounded memory lru backed by an optional disk or redis tier. Entries
+// of a code are dropped whenever the link changes or is removed.
+package qrcache
+
+import (
+	"context"
+	"errors"
+	"github.com/service-kit/short-url/common"
+	"github.com/service-kit/short-url/config"
+	"github.com/service-kit/short-url/data"
+	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/trace"
+	"go.uber.org/zap"
+	"sync"
+)
+
+const MB = 1024 * 1024
+
+type QRCacheManager struct {
+	memory *lru
+	tier   tier
+}
+
+var m *QRCacheManager
+var once sync.Once
+var logger *zap.Logger
+
+func GetInstance() *QRCacheManager {
+	once.Do(func() {
+		m = &QRCacheManager{}
+	})
+	return m
+}
+
+func (self *QRCacheManager) InitManager() error {
+	logger = log.GetInstance().GetLogger()
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	cacheConf := conf.Qr.Cache
+	self.memory = newLru(int64(cacheConf.MemoryMB) * MB)
+	switch cacheConf.Tier {
+	case common.QT_DISK:
+		disk, err := newDiskTier(cacheConf.Dir, int64(cacheConf.DiskMB)*MB)
+		if nil != err {
+			return err
+		}
+		self.tier = disk
+	case common.QT_REDIS:
+		self.tier = &redisTier{ttl: int64(cacheConf.TTL)}
+	}
+	data.GetInstance().Subscribe(self.Invalidate)
+	logger.Info("qr cache on", zap.Int("memory mb", cacheConf.MemoryMB), zap.String("tier", cacheConf.Tier))
+	return nil
+}
+
+// Get returns the rendering of code under key, building and caching it with
+// build on a miss
+func (self *QRCacheManager) Get(ctx context.Context, code, key string, build func() ([]byte, error)) ([]byte, error) {
+	ctx, span := trace.Start(ctx, "qrcache.Get")
+	defer span.End()
+	if value, ok := self.memory.get(code, key); ok {
+		span.SetAttribute("cache.hit", "memory")
+		return value, nil
+	}
+	if nil != self.tier {
+		if value, ok := self.tier.get(ctx, code, key); ok {
+			span.SetAttribute("cache.hit", "tier")
+			self.memory.set(code, key, value)
+			return value, nil
+		}
+	}
+	span.SetAttribute("cache.hit", "false")
+	value, err := build()
+	if nil != err {
+		span.SetError(err)
+		return nil, err
+	}
+	self.memory.set(code, key, value)
+	if nil != self.tier {
+		self.tier.set(ctx, code, key, value)
+	}
+	return value, nil
+}
+
+// Invalidate drops every rendering of code
+func (self *QRCacheManager) Invalidate(ctx context.Context, code string) {
+	self.memory.invalidate(code)
+	if nil != self.tier {
+		self.tier.invalidate(ctx, code)
+	}
+	log.FromContext(ctx).Debug("qr cache invalidated", zap.String("code", code))
+}
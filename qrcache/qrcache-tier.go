@@ -0,0 +1,215 @@
+package qrcache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/service-kit/short-url/redis"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tier is a second level behind the memory lru, shared by restarts or,
+// for redis, by every instance
+type tier interface {
+	get(ctx context.Context, code, key string) ([]byte, bool)
+	set(ctx context.Context, code, key string, data []byte)
+	invalidate(ctx context.Context, code string)
+}
+
+func hashName(str string) string {
+	sum := sha1.Sum([]byte(str))
+	return hex.EncodeToString(sum[:])
+}
+
+// diskTier keeps one file per rendering under dir/<code hash>/<key hash>,
+// hashing keeps any code or key out of the path
+type diskTier struct {
+	dir      string
+	maxBytes int64
+	bytes    int64
+	evicting sync.Mutex
+}
+
+func newDiskTier(dir string, maxBytes int64) (*diskTier, error) {
+	err := os.MkdirAll(dir, 0755)
+	if nil != err {
+		return nil, err
+	}
+	self := &diskTier{dir: dir, maxBytes: maxBytes}
+	for _, file := range self.files() {
+		self.bytes += file.Size()
+	}
+	return self, nil
+}
+
+func (self *diskTier) codeDir(code string) string {
+	return filepath.Join(self.dir, hashName(code))
+}
+
+func (self *diskTier) get(ctx context.Context, code, key string) ([]byte, bool) {
+	path := filepath.Join(self.codeDir(code), hashName(key))
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, false
+	}
+	// eviction goes by modification time, a hit keeps the file young
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+func (self *diskTier) set(ctx context.Context, code, key string, data []byte) {
+	dir := self.codeDir(code)
+	err := os.MkdirAll(dir, 0755)
+	if nil != err {
+		logger.Error("create qr cache dir err", zap.Error(err))
+		return
+	}
+	// write aside and rename so readers never see half a file
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if nil != err {
+		logger.Error("create qr cache file err", zap.Error(err))
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, hashName(key)))
+	}
+	if nil != err {
+		os.Remove(tmp.Name())
+		logger.Error("write qr cache file err", zap.Error(err))
+		return
+	}
+	if atomic.AddInt64(&self.bytes, int64(len(data))) > self.maxBytes {
+		go self.evict()
+	}
+}
+
+func (self *diskTier) invalidate(ctx context.Context, code string) {
+	dir := self.codeDir(code)
+	var size int64
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		size += info.Size()
+	}
+	err := os.RemoveAll(dir)
+	if nil != err {
+		logger.Error("remove qr cache dir err", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+	atomic.AddInt64(&self.bytes, -size)
+}
+
+type cacheFile struct {
+	os.FileInfo
+	path string
+}
+
+func (self *diskTier) files() []cacheFile {
+	var files []cacheFile
+	filepath.Walk(self.dir, func(path string, info os.FileInfo, err error) error {
+		if nil == err && info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".tmp") {
+			files = append(files, cacheFile{FileInfo: info, path: path})
+		}
+		return nil
+	})
+	return files
+}
+
+// evict removes the least recently used files down to 90% of maxBytes
+func (self *diskTier) evict() {
+	if !self.evicting.TryLock() {
+		return
+	}
+	defer self.evicting.Unlock()
+	files := self.files()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	var total int64
+	for _, file := range files {
+		total += file.Size()
+	}
+	target := self.maxBytes / 10 * 9
+	removed := 0
+	for _, file := range files {
+		if total <= target {
+			break
+		}
+		if nil == os.Remove(file.path) {
+			total -= file.Size()
+			removed++
+			os.Remove(filepath.Dir(file.path))
+		}
+	}
+	atomic.StoreInt64(&self.bytes, total)
+	logger.Info("qr cache evicted", zap.Int("files", removed), zap.Int64("bytes", total))
+}
+
+const REDIS_KEY_PREFIX = "qr_cache:"
+
+// redisTier stores renderings with a ttl, redis maxmemory bounds the rest
+type redisTier struct {
+	ttl int64
+}
+
+func (self *redisTier) redisKey(code, key string) string {
+	return REDIS_KEY_PREFIX + code + ":" + hashName(key)
+}
+
+func (self *redisTier) get(ctx context.Context, code, key string) ([]byte, bool) {
+	value, err := redis.GetInstance().GetStringValue(ctx, self.redisKey(code, key))
+	if nil != err || "" == value {
+		return nil, false
+	}
+	return []byte(value), true
+}
+
+func (self *redisTier) set(ctx context.Context, code, key string, data []byte) {
+	err := redis.GetInstance().SetStringValueWithExpireTime(ctx, self.redisKey(code, key), string(data), self.ttl)
+	if nil != err {
+		logger.Error("set qr cache to redis err", zap.Error(err))
+	}
+}
+
+// globEscape quotes the pattern characters of a SCAN match
+func globEscape(str string) string {
+	var b strings.Builder
+	for _, c := range str {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (self *redisTier) invalidate(ctx context.Context, code string) {
+	match := globEscape(REDIS_KEY_PREFIX+code+":") + "*"
+	cursor := "0"
+	for {
+		next, keys, err := redis.GetInstance().ScanKeys(ctx, cursor, match, 100)
+		if nil != err {
+			logger.Error("scan qr cache in redis err", zap.String("code", code), zap.Error(err))
+			return
+		}
+		for _, key := range keys {
+			redis.GetInstance().DelKey(ctx, key)
+		}
+		if "0" == next {
+			return
+		}
+		cursor = next
+	}
+}
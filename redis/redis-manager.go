@@ -1,8 +1,12 @@
 package redis
 
 import (
+	"context"
+	"errors"
+	redigo "github.com/garyburd/redigo/redis"
 	"github.com/service-kit/short-url/config"
 	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/trace"
 	"go.uber.org/zap"
 	"sync"
 )
@@ -24,40 +28,91 @@ func GetInstance() *RedisManager {
 
 func (self *RedisManager) InitManager() error {
 	logger = log.GetInstance().GetLogger()
-	host, err := config.GetInstance().GetConfig("REDIS_ADDR")
-	if nil != err {
-		logger.Error("RedisMAnager InitManager fail! Can not find conf REDIS_HOST!!!")
-		return err
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		logger.Error("RedisMAnager InitManager fail! Config is not loaded!!!")
+		return errors.New("config is not loaded")
 	}
-	passwd, err := config.GetInstance().GetConfig("REDIS_PASSWD")
-	if nil != err {
-		logger.Error("RedisMAnager InitManager fail! Can not find conf REDIS_PASSWD!!!")
-		return err
-	}
-	self.redisPool.Init(host, passwd)
+	self.redisPool.Init(conf.Redis)
 	return nil
 }
 
-func (self *RedisManager) SetStringValue(key, value string) (err error) {
+func startSpan(ctx context.Context, cmd, key string) *trace.Span {
+	_, span := trace.Start(ctx, "redis."+cmd)
+	span.SetAttribute("db.system", "redis")
+	span.SetAttribute("db.redis.key", key)
+	return span
+}
+
+// IsNil reports whether err means the key does not exist
+func IsNil(err error) bool {
+	return redigo.ErrNil == err
+}
+
+// endSpan ends span, a missing key is not an error
+func endSpan(span *trace.Span, err error) {
+	if !IsNil(err) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (self *RedisManager) SetStringValue(ctx context.Context, key, value string) (err error) {
+	span := startSpan(ctx, "SET", key)
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.SetStringValue(key, value)
 }
 
-func (self *RedisManager) SetStringValueWithExpireTime(key, value string, expireTime int64) (err error) {
+func (self *RedisManager) SetStringValueWithExpireTime(ctx context.Context, key, value string, expireTime int64) (err error) {
+	span := startSpan(ctx, "SET", key)
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.SetStringValueWithExpireTime(key, value, expireTime)
 }
 
-func (self *RedisManager) SetMultiValueWithExpireTime(kvMap map[string]string, ktMap map[string]int64) (err error) {
+func (self *RedisManager) SetMultiValueWithExpireTime(ctx context.Context, kvMap map[string]string, ktMap map[string]int64) (err error) {
+	span := startSpan(ctx, "MSET", "")
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.SetMultiValueWithExpireTime(kvMap, ktMap)
 }
 
-func (self *RedisManager) GetStringValue(key string) (out string, err error) {
+func (self *RedisManager) GetStringValue(ctx context.Context, key string) (out string, err error) {
+	span := startSpan(ctx, "GET", key)
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.GetStringValue(key)
 }
 
-func (self *RedisManager) GetMultiValue(keys ...interface{}) (out map[string]string, err error) {
+func (self *RedisManager) GetMultiValue(ctx context.Context, keys ...interface{}) (out map[string]string, err error) {
+	span := startSpan(ctx, "MGET", "")
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.GetMultiValue(keys)
 }
 
-func (self *RedisManager) GetKeyExpire(key string) (out int64, err error) {
+func (self *RedisManager) DelKey(ctx context.Context, key string) (err error) {
+	span := startSpan(ctx, "DEL", key)
+	defer func() { endSpan(span, err) }()
+	return self.redisPool.DelKey(key)
+}
+
+func (self *RedisManager) IncrValue(ctx context.Context, key string) (out int64, err error) {
+	span := startSpan(ctx, "INCR", key)
+	defer func() { endSpan(span, err) }()
+	return self.redisPool.IncrValue(key)
+}
+
+func (self *RedisManager) IncrValueBy(ctx context.Context, key string, n int64) (out int64, err error) {
+	span := startSpan(ctx, "INCRBY", key)
+	defer func() { endSpan(span, err) }()
+	return self.redisPool.IncrValueBy(key, n)
+}
+
+func (self *RedisManager) ScanKeys(ctx context.Context, cursor, match string, count int) (next string, keys []string, err error) {
+	span := startSpan(ctx, "SCAN", match)
+	defer func() { endSpan(span, err) }()
+	return self.redisPool.ScanKeys(cursor, match, count)
+}
+
+func (self *RedisManager) GetKeyExpire(ctx context.Context, key string) (out int64, err error) {
+	span := startSpan(ctx, "TTL", key)
+	defer func() { endSpan(span, err) }()
 	return self.redisPool.GetKeyExpire(key)
 }
@@ -4,46 +4,25 @@ import (
 	"errors"
 	"github.com/garyburd/redigo/redis"
 	"github.com/service-kit/short-url/config"
-	"go.uber.org/zap"
 	"time"
 )
 
-const (
-	REDIS_POOL_MAX_IDLE     = 512
-	REDIS_POOL_MAX_ACTIVE   = 1024
-	REDIS_POOL_IDLE_TIMEOUT = 240
-)
-
 type RedisPool struct {
 	redisPool *redis.Pool
 	isInit    bool
 }
 
-func (self *RedisPool) Init(host, passwd string) {
-	self.redisPool = self.newPool(host, passwd)
+func (self *RedisPool) Init(conf config.RedisConfig) {
+	self.redisPool = self.newPool(conf)
 	self.isInit = true
 }
 
-func (self *RedisPool) newPool(host, passwd string) *redis.Pool {
-	maxIdle, err := config.GetInstance().GetInt("REDIS_POOL_MAX_IDLE")
-	if nil != err {
-		logger.Error("get config fail", zap.String("cfg", "REDIS_POOL_MAX_IDLE"), zap.Error(err))
-		maxIdle = REDIS_POOL_MAX_IDLE
-	}
-	maxActive, err := config.GetInstance().GetInt("REDIS_POOL_MAX_ACTIVE")
-	if nil != err {
-		logger.Error("get config fail", zap.String("cfg", "REDIS_POOL_MAX_ACTIVE"), zap.Error(err))
-		maxActive = REDIS_POOL_MAX_ACTIVE
-	}
-	idleTimeout, err := config.GetInstance().GetInt("REDIS_POOL_IDLE_TIMEOUT")
-	if nil != err {
-		logger.Error("get config fail", zap.String("cfg", "REDIS_POOL_IDLE_TIMEOUT"), zap.Error(err))
-		idleTimeout = REDIS_POOL_IDLE_TIMEOUT
-	}
+func (self *RedisPool) newPool(conf config.RedisConfig) *redis.Pool {
+	host, passwd := conf.Addr, conf.Passwd
 	return &redis.Pool{
-		MaxIdle:     maxIdle,
-		MaxActive:   maxActive,
-		IdleTimeout: time.Duration(idleTimeout) * time.Second,
+		MaxIdle:     conf.PoolMaxIdle,
+		MaxActive:   conf.PoolMaxActive,
+		IdleTimeout: time.Duration(conf.PoolIdleTimeout) * time.Second,
 		Dial: func() (redis.Conn, error) {
 			c, err := redis.Dial("tcp", host)
 			if err != nil {
@@ -238,6 +217,91 @@ func (self *RedisPool) setMultiValue(kvMap map[string]string) error {
 	return err
 }
 
+func (self *RedisPool) delValue(key string) error {
+	if !self.isInit {
+		return errors.New(REDIS_UNAVAILABLE)
+	}
+	conn := self.redisPool.Get()
+	if nil == conn {
+		return errors.New(REDIS_UNAVAILABLE)
+	}
+	if nil != conn.Err() {
+		return errors.New(REDIS_UNAVAILABLE)
+	}
+	defer conn.Close()
+	_, err := conn.Do("DEL", key)
+	return err
+}
+
+func (self *RedisPool) DelKey(key string) error {
+	return self.delValue(key)
+}
+
+func (self *RedisPool) incrValue(key string) (int64, error) {
+	if !self.isInit {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	conn := self.redisPool.Get()
+	if nil == conn {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	if nil != conn.Err() {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCR", key))
+}
+
+func (self *RedisPool) IncrValue(key string) (int64, error) {
+	return self.incrValue(key)
+}
+
+func (self *RedisPool) incrValueBy(key string, n int64) (int64, error) {
+	if !self.isInit {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	conn := self.redisPool.Get()
+	if nil == conn {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	if nil != conn.Err() {
+		return 0, errors.New(REDIS_UNAVAILABLE)
+	}
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCRBY", key, n))
+}
+
+func (self *RedisPool) IncrValueBy(key string, n int64) (int64, error) {
+	return self.incrValueBy(key, n)
+}
+
+func (self *RedisPool) scanKeys(cursor, match string, count int) (string, []string, error) {
+	if !self.isInit {
+		return "", nil, errors.New(REDIS_UNAVAILABLE)
+	}
+	conn := self.redisPool.Get()
+	if nil == conn {
+		return "", nil, errors.New(REDIS_UNAVAILABLE)
+	}
+	if nil != conn.Err() {
+		return "", nil, errors.New(REDIS_UNAVAILABLE)
+	}
+	defer conn.Close()
+	values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", count))
+	if nil != err {
+		return "", nil, err
+	}
+	var next string
+	var keys []string
+	_, err = redis.Scan(values, &next, &keys)
+	return next, keys, err
+}
+
+// ScanKeys runs one SCAN step, the returned cursor is "0" once done
+func (self *RedisPool) ScanKeys(cursor, match string, count int) (string, []string, error) {
+	return self.scanKeys(cursor, match, count)
+}
+
 func (self *RedisPool) GetStringValue(key string) (string, error) {
 	return redis.String(self.getValue(key))
 }
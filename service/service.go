@@ -1,10 +1,15 @@
 package service
 
 import (
+	"context"
+	"github.com/service-kit/short-url/brand"
 	"github.com/service-kit/short-url/config"
 	"github.com/service-kit/short-url/data"
 	"github.com/service-kit/short-url/http"
 	"github.com/service-kit/short-url/log"
+	"github.com/service-kit/short-url/page"
+	"github.com/service-kit/short-url/policy"
+	"github.com/service-kit/short-url/qrcache"
 	"github.com/service-kit/short-url/redis"
 	"github.com/service-kit/short-url/storage"
 	"go.uber.org/zap"
@@ -32,14 +37,31 @@ func StartService() {
 	}
 }
 
-func initManager() error {
-	var err error = nil
-	err = log.GetInstance().InitManager()
+// InitConfigManager prepares config and then log, so the log settings are
+// honoured; log falls back to defaults when config fails to load
+func InitConfigManager() error {
+	confErr := config.GetInstance().InitManager()
+	err := log.GetInstance().InitManager()
 	if nil != err {
 		return err
 	}
 	logger = log.GetInstance().GetLogger()
-	err = config.GetInstance().InitManager()
+	config.SetLogger(logger)
+	return confErr
+}
+
+// InitStorageManager prepares config and storage, which migrates the db schema
+func InitStorageManager() error {
+	err := InitConfigManager()
+	if nil != err {
+		return err
+	}
+	return storage.GetInstance().InitManager()
+}
+
+// InitBaseManager prepares everything except the http server, used by cli commands
+func InitBaseManager() error {
+	err := InitConfigManager()
 	if nil != err {
 		return err
 	}
@@ -51,10 +73,38 @@ func initManager() error {
 	if nil != err {
 		return err
 	}
+	err = brand.GetInstance().InitManager()
+	if nil != err {
+		return err
+	}
 	err = data.GetInstance().InitManager()
 	if nil != err {
 		return err
 	}
+	return policy.GetInstance().InitManager()
+}
+
+func initManager() error {
+	err := InitBaseManager()
+	if nil != err {
+		return err
+	}
+	err = data.GetInstance().Preload(context.Background())
+	if nil != err {
+		logger.Warn("preload short urls err, load them on demand", zap.Error(err))
+	}
+	// the qr cache only serves the http server, cli commands render uncached
+	err = qrcache.GetInstance().InitManager()
+	if nil != err {
+		return err
+	}
+	err = page.GetInstance().InitManager()
+	if nil != err {
+		return err
+	}
+	config.GetInstance().Watch(time.Second)
+	policy.GetInstance().Watch(time.Second)
+	data.GetInstance().FlushClicks(time.Second)
 	wg.Add(1)
 	err = http.GetInstance().InitManager(&wg)
 	if nil != err {
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
@@ -8,10 +10,18 @@ import (
 	"github.com/service-kit/short-url/config"
 	"github.com/service-kit/short-url/log"
 	"github.com/service-kit/short-url/redis"
+	"github.com/service-kit/short-url/trace"
+	"github.com/service-kit/short-url/util"
 	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 )
 
+// SHORT_URL_INDEX is the unique index of common.ShortUrlInfo on short_url
+const SHORT_URL_INDEX = "uix_short_url"
+
 type StorageManager struct {
 	MysqlParam  string
 	mysqlSwitch bool
@@ -19,7 +29,6 @@ type StorageManager struct {
 
 var m *StorageManager
 var once sync.Once
-var logger *zap.Logger
 
 func GetInstance() *StorageManager {
 	once.Do(func() {
@@ -29,30 +38,46 @@ func GetInstance() *StorageManager {
 }
 
 func (self *StorageManager) InitManager() error {
-	logger = log.GetInstance().GetLogger()
-	swi, _ := config.GetInstance().GetInt("MYSQL_SWITCH")
-	if common.SWITHC_ON != swi {
+	conf := config.GetInstance().Config()
+	if nil == conf {
+		return errors.New("config is not loaded")
+	}
+	if !conf.Mysql.Switch {
 		self.mysqlSwitch = false
 		return nil
 	}
 	self.mysqlSwitch = true
-	err := self.loadConfig()
-	if nil != err {
-		return err
-	}
+	self.MysqlParam = GenerateMysqlParam(conf.Mysql.Addr, conf.Mysql.User, conf.Mysql.Passwd, conf.Mysql.DBName)
 	return self.initDB()
 }
 
 func (self *StorageManager) initDB() error {
+	return self.Migrate()
+}
+
+// Migrate creates or updates the db schema, a no-op with mysql switched off
+func (self *StorageManager) Migrate() error {
+	if !self.mysqlSwitch {
+		return nil
+	}
 	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
 	defer db.Close()
-	if !db.HasTable(&common.ShortUrlInfo{}) {
-		db.CreateTable(&common.ShortUrlInfo{})
+	if err := db.AutoMigrate(&common.ShortUrlInfo{}).Error; nil != err {
+		return err
+	}
+	// selectPage seeks on short_url, without the index every page scans the table
+	table := db.NewScope(&common.ShortUrlInfo{}).TableName()
+	if !db.Dialect().HasIndex(table, SHORT_URL_INDEX) {
+		return errors.New("index " + SHORT_URL_INDEX + " on " + table + " is missing")
 	}
-	return db.Error
+	return nil
+}
+
+func (self *StorageManager) IsMysqlOn() bool {
+	return self.mysqlSwitch
 }
 
 func (self *StorageManager) getDBCon() (*gorm.DB, error) {
@@ -62,6 +87,20 @@ func (self *StorageManager) getDBCon() (*gorm.DB, error) {
 	return gorm.Open("mysql", self.MysqlParam)
 }
 
+// startSpan starts a span named "<system>.<op>" under the span in ctx
+func startSpan(ctx context.Context, system, op string) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, system+"."+op)
+	if "mysql" == system {
+		span.SetAttribute("db.system", system)
+	}
+	return ctx, span
+}
+
+func endSpan(span *trace.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
 func (self *StorageManager) exist(data interface{}) bool {
 	db, err := self.getDBCon()
 	if nil != err {
@@ -71,7 +110,9 @@ func (self *StorageManager) exist(data interface{}) bool {
 	return !db.First(data).RecordNotFound()
 }
 
-func (self *StorageManager) insert(data interface{}) error {
+func (self *StorageManager) insert(ctx context.Context, data interface{}) (err error) {
+	_, span := startSpan(ctx, "mysql", "INSERT")
+	defer func() { endSpan(span, err) }()
 	db, err := self.getDBCon()
 	if nil != err {
 		return err
@@ -89,7 +130,9 @@ func (self *StorageManager) update(data interface{}) error {
 	return db.Save(data).Error
 }
 
-func (self *StorageManager) delete(data interface{}) error {
+func (self *StorageManager) delete(ctx context.Context, data interface{}) (err error) {
+	_, span := startSpan(ctx, "mysql", "DELETE")
+	defer func() { endSpan(span, err) }()
 	db, err := self.getDBCon()
 	if nil != err {
 		return err
@@ -107,114 +150,492 @@ func (self *StorageManager) query(data interface{}) error {
 	return db.Where(data).First(data).Error
 }
 
-func (self *StorageManager) loadConfig() error {
-	addr, err := config.GetInstance().GetConfig("DB_ADDR")
+func GenerateMysqlParam(addr, user, passwd, dbName string) string {
+	return user + ":" + passwd + "@tcp(" + addr + ")/" + dbName + "?charset=utf8"
+}
+
+func (self *StorageManager) raw(sql string, out interface{}) error {
+	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
-	user, err := config.GetInstance().GetConfig("DB_USER")
+	defer db.Close()
+	return db.Exec(sql).Find(out).Error
+}
+
+func (self *StorageManager) selectWithOrderAndLimit(cond, order string, limit int, out interface{}) error {
+	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
-	passwd, err := config.GetInstance().GetConfig("DB_PASSWD")
+	defer db.Close()
+	return db.Where(cond).Order(order).Limit(limit).Find(out).Error
+}
+
+func (self *StorageManager) selectAll(ctx context.Context, out interface{}) (err error) {
+	_, span := startSpan(ctx, "mysql", "SELECT")
+	defer func() { endSpan(span, err) }()
+	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
-	dbname, err := config.GetInstance().GetConfig("DB_DBNAME")
+	defer db.Close()
+	return db.Find(out).Error
+}
+
+// selectPage reads the links after the given short url in its order, Migrate
+// makes sure SHORT_URL_INDEX serves the seek
+func (self *StorageManager) selectPage(ctx context.Context, after string, limit int, out interface{}) (err error) {
+	_, span := startSpan(ctx, "mysql", "SELECT")
+	defer func() { endSpan(span, err) }()
+	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
-	self.MysqlParam = GenerateMysqlParam(addr, user, passwd, dbname)
-	return err
+	defer db.Close()
+	return db.Where("short_url > ?", after).Order("short_url").Limit(limit).Find(out).Error
 }
 
-func GenerateMysqlParam(addr, user, passwd, dbName string) string {
-	return user + ":" + passwd + "@tcp(" + addr + ")/" + dbName + "?charset=utf8"
+func (self StorageManager) generateShortUrlKey(clientID string) string {
+	return "short_url:" + clientID
 }
 
-func (self *StorageManager) raw(sql string, out interface{}) error {
+func (self StorageManager) generateClicksKey(clientID string) string {
+	return "short_url_clicks:" + clientID
+}
+
+func (self *StorageManager) existShortUrl(ctx context.Context, short_url string) bool {
+	_, span := startSpan(ctx, "mysql", "SELECT")
+	defer span.End()
 	db, err := self.getDBCon()
 	if nil != err {
-		return err
+		return false
 	}
 	defer db.Close()
-	return db.Exec(sql).Find(out).Error
+	return !db.Where("short_url = ?", short_url).First(&common.ShortUrlInfo{}).RecordNotFound()
 }
 
-func (self *StorageManager) selectWithOrderAndLimit(cond, order string, limit int, out interface{}) error {
+func (self *StorageManager) queryShortUrl(ctx context.Context, short_url string, out *common.ShortUrlInfo) (err error) {
+	_, span := startSpan(ctx, "mysql", "SELECT")
+	defer func() { endSpan(span, err) }()
 	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
 	defer db.Close()
-	return db.Where(cond).Order(order).Limit(limit).Find(out).Error
+	return db.Where("short_url = ?", short_url).First(out).Error
 }
 
-func (self *StorageManager) selectAll(out interface{}) error {
+func (self *StorageManager) updateColumns(ctx context.Context, short_url string, columns map[string]interface{}) (err error) {
+	_, span := startSpan(ctx, "mysql", "UPDATE")
+	defer func() { endSpan(span, err) }()
 	db, err := self.getDBCon()
 	if nil != err {
 		return err
 	}
 	defer db.Close()
-	return db.Find(out).Error
+	return db.Model(&common.ShortUrlInfo{}).Where("short_url = ?", short_url).Updates(columns).Error
 }
 
-func (self StorageManager) generateShortUrlKey(clientID string) string {
-	return "short_url:" + clientID
+func (self *StorageManager) syncToRedis(ctx context.Context, short_url_info *common.ShortUrlInfo) error {
+	value, err := json.Marshal(short_url_info)
+	if nil != err {
+		return err
+	}
+	return redis.GetInstance().SetStringValue(ctx, self.generateShortUrlKey(short_url_info.ShortUrl), string(value))
+}
+
+// IsNotExist reports whether err means the link is stored nowhere, as
+// opposed to storage being unreachable
+func IsNotExist(err error) bool {
+	return nil != err && common.ERROR_NOT_EXIST == err.Error()
+}
+
+func (self *StorageManager) loadFromRedis(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	value, err := redis.GetInstance().GetStringValue(ctx, self.generateShortUrlKey(short_url))
+	if redis.IsNil(err) || (nil == err && "" == value) {
+		return nil, errors.New(common.ERROR_NOT_EXIST)
+	}
+	if nil != err {
+		return nil, err
+	}
+	short_url_info := new(common.ShortUrlInfo)
+	if !strings.HasPrefix(value, "{") {
+		// values written before link status existed hold the bare original url
+		short_url_info.ShortUrl = short_url
+		short_url_info.OriginalUrl = value
+		return short_url_info, nil
+	}
+	err = json.Unmarshal([]byte(value), short_url_info)
+	if nil != err {
+		return nil, err
+	}
+	return short_url_info, nil
 }
 
-func (self *StorageManager) StorageShortUrlInfo(short_url *common.ShortUrlInfo) (bool, error) {
+func (self *StorageManager) StorageShortUrlInfo(ctx context.Context, short_url *common.ShortUrlInfo) (exist bool, err error) {
+	ctx, span := startSpan(ctx, "storage", "StorageShortUrlInfo")
+	defer func() { endSpan(span, err) }()
+	now := util.GetCurrentSeconds()
+	if 0 == short_url.CreateTime {
+		short_url.CreateTime = now
+	}
+	short_url.UpdateTime = now
 	if self.mysqlSwitch {
-		exist := self.exist(short_url)
-		if exist {
+		if self.existShortUrl(ctx, short_url.ShortUrl) {
 			return true, errors.New("short url exist")
 		}
-		err := self.insert(short_url)
+		err = self.insert(ctx, short_url)
 		if nil != err {
-			logger.Error("storage short url to db err", zap.Error(err))
+			log.FromContext(ctx).Error("storage short url to db err", zap.Error(err))
 			return false, err
 		}
 	}
-	original_url, _ := redis.GetInstance().GetStringValue(self.generateShortUrlKey(short_url.ShortUrl))
-	if "" != original_url {
+	exist_info, _ := self.loadFromRedis(ctx, short_url.ShortUrl)
+	if nil != exist_info {
 		return false, errors.New("short url exist")
 	}
-	return false, redis.GetInstance().SetStringValue(self.generateShortUrlKey(short_url.ShortUrl), short_url.OriginalUrl)
+	return false, self.syncToRedis(ctx, short_url)
+}
+
+func (self *StorageManager) insertBatch(ctx context.Context, short_urls []*common.ShortUrlInfo) (err error) {
+	_, span := startSpan(ctx, "mysql", "INSERT")
+	defer func() { endSpan(span, err) }()
+	db, err := self.getDBCon()
+	if nil != err {
+		return err
+	}
+	defer db.Close()
+	tx := db.Begin()
+	for _, short_url := range short_urls {
+		err = tx.Create(short_url).Error
+		if nil != err {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
 }
 
-func (self *StorageManager) GetOriginalUrl(short_url string) (string, error) {
-	original_url, err := redis.GetInstance().GetStringValue(self.generateShortUrlKey(short_url))
-	if nil == err && "" != original_url {
-		return original_url, err
+// StorageShortUrlInfos stores short_urls in one db transaction, the returned
+// errors line up with short_urls; callers are expected to have checked codes
+func (self *StorageManager) StorageShortUrlInfos(ctx context.Context, short_urls []*common.ShortUrlInfo) []error {
+	ctx, span := startSpan(ctx, "storage", "StorageShortUrlInfos")
+	defer span.End()
+	errs := make([]error, len(short_urls))
+	now := util.GetCurrentSeconds()
+	for _, short_url := range short_urls {
+		if 0 == short_url.CreateTime {
+			short_url.CreateTime = now
+		}
+		short_url.UpdateTime = now
+	}
+	if self.mysqlSwitch {
+		err := self.insertBatch(ctx, short_urls)
+		if nil != err {
+			log.FromContext(ctx).Error("storage short url batch to db err", zap.Int("size", len(short_urls)), zap.Error(err))
+			span.SetError(err)
+			for i := range errs {
+				errs[i] = err
+			}
+			return errs
+		}
+	}
+	for i, short_url := range short_urls {
+		errs[i] = self.syncToRedis(ctx, short_url)
+	}
+	return errs
+}
+
+func (self *StorageManager) GetShortUrlInfo(ctx context.Context, short_url string) (short_url_info *common.ShortUrlInfo, err error) {
+	ctx, span := startSpan(ctx, "storage", "GetShortUrlInfo")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err = self.loadFromRedis(ctx, short_url)
+	if nil == err {
+		return short_url_info, nil
 	}
 	if !self.mysqlSwitch {
-		return "", errors.New("not register")
+		return nil, err
+	}
+	short_url_info = new(common.ShortUrlInfo)
+	err = self.queryShortUrl(ctx, short_url, short_url_info)
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, errors.New(common.ERROR_NOT_EXIST)
 	}
-	short_url_info := new(common.ShortUrlInfo)
-	short_url_info.ShortUrl = short_url
-	err = self.query(short_url_info)
 	if nil != err {
-		logger.Error("query short url info from db err", zap.Error(err))
+		log.FromContext(ctx).Error("query short url info from db err", zap.Error(err))
+		return nil, err
+	}
+	log.FromContext(ctx).Info("sync short url info to redis ", zap.String("id", short_url_info.ShortUrl))
+	err = self.syncToRedis(ctx, short_url_info)
+	if nil != err {
+		log.FromContext(ctx).Error("sync to redis short url info err", zap.Error(err))
+	}
+	return short_url_info, nil
+}
+
+func (self *StorageManager) GetOriginalUrl(ctx context.Context, short_url string) (string, error) {
+	short_url_info, err := self.GetShortUrlInfo(ctx, short_url)
+	if nil != err {
 		return "", err
 	}
-	if "" != short_url_info.ShortUrl {
-		logger.Info("sync short url info to redis ", zap.String("id", short_url_info.OriginalUrl))
-		err = redis.GetInstance().SetStringValue(self.generateShortUrlKey(short_url_info.OriginalUrl), short_url_info.ShortUrl)
+	return short_url_info.OriginalUrl, nil
+}
+
+func (self *StorageManager) updateStatus(ctx context.Context, op, short_url string, status int, reason string) (short_url_info *common.ShortUrlInfo, err error) {
+	ctx, span := startSpan(ctx, "storage", op)
+	defer func() { endSpan(span, err) }()
+	short_url_info, err = self.GetShortUrlInfo(ctx, short_url)
+	if nil != err {
+		return nil, err
+	}
+	short_url_info.Status = status
+	short_url_info.Reason = reason
+	short_url_info.UpdateTime = util.GetCurrentSeconds()
+	if self.mysqlSwitch {
+		err = self.updateColumns(ctx, short_url, map[string]interface{}{
+			"status":      short_url_info.Status,
+			"reason":      short_url_info.Reason,
+			"update_time": short_url_info.UpdateTime,
+		})
 		if nil != err {
-			logger.Error("sync to redis short url info err", zap.Error(err))
+			log.FromContext(ctx).Error("update short url status err", zap.String("short url", short_url), zap.Error(err))
+			return nil, err
 		}
 	}
-	return short_url_info.ShortUrl, nil
+	return short_url_info, self.syncToRedis(ctx, short_url_info)
 }
 
-func (self *StorageManager) LoadAllShortUrlData() ([]common.ShortUrlInfo, error) {
+// SetInterstitial turns the preview page before redirecting on or off
+func (self *StorageManager) SetInterstitial(ctx context.Context, short_url string, on bool) (short_url_info *common.ShortUrlInfo, err error) {
+	ctx, span := startSpan(ctx, "storage", "SetInterstitial")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err = self.GetShortUrlInfo(ctx, short_url)
+	if nil != err {
+		return nil, err
+	}
+	short_url_info.Interstitial = on
+	short_url_info.UpdateTime = util.GetCurrentSeconds()
+	if self.mysqlSwitch {
+		err = self.updateColumns(ctx, short_url, map[string]interface{}{
+			"interstitial": short_url_info.Interstitial,
+			"update_time":  short_url_info.UpdateTime,
+		})
+		if nil != err {
+			log.FromContext(ctx).Error("update short url interstitial err", zap.String("short url", short_url), zap.Error(err))
+			return nil, err
+		}
+	}
+	return short_url_info, self.syncToRedis(ctx, short_url_info)
+}
+
+// SetPasswordHash protects the link by a password hash, empty removes it
+func (self *StorageManager) SetPasswordHash(ctx context.Context, short_url, password_hash string) (short_url_info *common.ShortUrlInfo, err error) {
+	ctx, span := startSpan(ctx, "storage", "SetPasswordHash")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err = self.GetShortUrlInfo(ctx, short_url)
+	if nil != err {
+		return nil, err
+	}
+	short_url_info.PasswordHash = password_hash
+	short_url_info.UpdateTime = util.GetCurrentSeconds()
+	if self.mysqlSwitch {
+		err = self.updateColumns(ctx, short_url, map[string]interface{}{
+			"password_hash": short_url_info.PasswordHash,
+			"update_time":   short_url_info.UpdateTime,
+		})
+		if nil != err {
+			log.FromContext(ctx).Error("update short url password err", zap.String("short url", short_url), zap.Error(err))
+			return nil, err
+		}
+	}
+	return short_url_info, self.syncToRedis(ctx, short_url_info)
+}
+
+// DeleteShortUrl marks the link deleted, it stays restorable until purged
+func (self *StorageManager) DeleteShortUrl(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	return self.updateStatus(ctx, "DeleteShortUrl", short_url, common.LS_DELETED, "")
+}
+
+func (self *StorageManager) DisableShortUrl(ctx context.Context, short_url, reason string) (*common.ShortUrlInfo, error) {
+	return self.updateStatus(ctx, "DisableShortUrl", short_url, common.LS_DISABLED, reason)
+}
+
+func (self *StorageManager) RestoreShortUrl(ctx context.Context, short_url string) (*common.ShortUrlInfo, error) {
+	return self.updateStatus(ctx, "RestoreShortUrl", short_url, common.LS_ACTIVE, "")
+}
+
+// PurgeShortUrl removes the link from db and redis for good
+func (self *StorageManager) PurgeShortUrl(ctx context.Context, short_url string) (short_url_info *common.ShortUrlInfo, err error) {
+	ctx, span := startSpan(ctx, "storage", "PurgeShortUrl")
+	defer func() { endSpan(span, err) }()
+	short_url_info, err = self.GetShortUrlInfo(ctx, short_url)
+	if nil != err {
+		return nil, err
+	}
+	if self.mysqlSwitch {
+		err = self.delete(ctx, short_url_info)
+		if nil != err {
+			log.FromContext(ctx).Error("purge short url from db err", zap.String("short url", short_url), zap.Error(err))
+			return nil, err
+		}
+	}
+	redis.GetInstance().DelKey(ctx, self.generateClicksKey(short_url))
+	return short_url_info, redis.GetInstance().DelKey(ctx, self.generateShortUrlKey(short_url))
+}
+
+func (self *StorageManager) LoadAllShortUrlData(ctx context.Context) ([]common.ShortUrlInfo, error) {
 	if !self.mysqlSwitch {
 		return nil, nil
 	}
 	var infos []common.ShortUrlInfo
-	err := self.selectAll(&infos)
+	err := self.selectAll(ctx, &infos)
 	if nil != err {
 		return nil, err
 	}
 	return infos, nil
 }
+
+// IncrClicks adds n to the redis click counter of short_url
+func (self *StorageManager) IncrClicks(ctx context.Context, short_url string, n int64) (err error) {
+	ctx, span := startSpan(ctx, "storage", "IncrClicks")
+	defer func() { endSpan(span, err) }()
+	_, err = redis.GetInstance().IncrValueBy(ctx, self.generateClicksKey(short_url), n)
+	return err
+}
+
+// AddClicks adds counts to the db click counters in one transaction, a
+// no-op with mysql switched off
+func (self *StorageManager) AddClicks(ctx context.Context, counts map[string]int64) (err error) {
+	if !self.mysqlSwitch {
+		return nil
+	}
+	_, span := startSpan(ctx, "mysql", "UPDATE")
+	defer func() { endSpan(span, err) }()
+	db, err := self.getDBCon()
+	if nil != err {
+		return err
+	}
+	defer db.Close()
+	// the same order everywhere, so instances do not deadlock on the rows
+	short_urls := make([]string, 0, len(counts))
+	for short_url := range counts {
+		short_urls = append(short_urls, short_url)
+	}
+	sort.Strings(short_urls)
+	tx := db.Begin()
+	for _, short_url := range short_urls {
+		err = tx.Model(&common.ShortUrlInfo{}).Where("short_url = ?", short_url).Updates(map[string]interface{}{"clicks": gorm.Expr("clicks + ?", counts[short_url])}).Error
+		if nil != err {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetClicks returns the click counter of short_url, falling back to the
+// stored value when redis has no counter
+func (self *StorageManager) GetClicks(ctx context.Context, short_url_info *common.ShortUrlInfo) int64 {
+	value, err := redis.GetInstance().GetStringValue(ctx, self.generateClicksKey(short_url_info.ShortUrl))
+	if nil != err || "" == value {
+		return short_url_info.Clicks
+	}
+	clicks, err := strconv.ParseInt(value, 10, 64)
+	if nil != err || clicks < short_url_info.Clicks {
+		return short_url_info.Clicks
+	}
+	return clicks
+}
+
+// ForEachShortUrlInfo walks every stored link pageSize at a time, from mysql
+// ordered by short url when enabled, otherwise by scanning redis
+func (self *StorageManager) ForEachShortUrlInfo(ctx context.Context, pageSize int, fn func(*common.ShortUrlInfo) error) (err error) {
+	ctx, span := startSpan(ctx, "storage", "ForEachShortUrlInfo")
+	defer func() { endSpan(span, err) }()
+	if self.mysqlSwitch {
+		after := ""
+		for {
+			var infos []common.ShortUrlInfo
+			err = self.selectPage(ctx, after, pageSize, &infos)
+			if nil != err {
+				return err
+			}
+			for i := range infos {
+				infos[i].Clicks = self.GetClicks(ctx, &infos[i])
+				err = fn(&infos[i])
+				if nil != err {
+					return err
+				}
+			}
+			if len(infos) < pageSize {
+				return nil
+			}
+			after = infos[len(infos)-1].ShortUrl
+		}
+	}
+	cursor := "0"
+	prefix := self.generateShortUrlKey("")
+	for {
+		var next string
+		var keys []string
+		next, keys, err = redis.GetInstance().ScanKeys(ctx, cursor, prefix+"*", pageSize)
+		if nil != err {
+			return err
+		}
+		for _, key := range keys {
+			info, loadErr := self.loadFromRedis(ctx, strings.TrimPrefix(key, prefix))
+			if nil != loadErr {
+				continue
+			}
+			info.Clicks = self.GetClicks(ctx, info)
+			err = fn(info)
+			if nil != err {
+				return err
+			}
+		}
+		if "0" == next {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (self *StorageManager) replaceBatch(ctx context.Context, short_url *common.ShortUrlInfo) (err error) {
+	_, span := startSpan(ctx, "mysql", "REPLACE")
+	defer func() { endSpan(span, err) }()
+	db, err := self.getDBCon()
+	if nil != err {
+		return err
+	}
+	defer db.Close()
+	tx := db.Begin()
+	err = tx.Where("short_url = ?", short_url.ShortUrl).Delete(&common.ShortUrlInfo{}).Error
+	if nil == err {
+		err = tx.Create(short_url).Error
+	}
+	if nil != err {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// ReplaceShortUrlInfo stores short_url as is, replacing any link with the
+// same code and its click counter
+func (self *StorageManager) ReplaceShortUrlInfo(ctx context.Context, short_url *common.ShortUrlInfo) (err error) {
+	ctx, span := startSpan(ctx, "storage", "ReplaceShortUrlInfo")
+	defer func() { endSpan(span, err) }()
+	if self.mysqlSwitch {
+		err = self.replaceBatch(ctx, short_url)
+		if nil != err {
+			log.FromContext(ctx).Error("replace short url in db err", zap.String("short url", short_url.ShortUrl), zap.Error(err))
+			return err
+		}
+	}
+	err = redis.GetInstance().SetStringValue(ctx, self.generateClicksKey(short_url.ShortUrl), strconv.FormatInt(short_url.Clicks, 10))
+	if nil != err {
+		return err
+	}
+	return self.syncToRedis(ctx, short_url)
+}
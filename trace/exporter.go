@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Exporter receives every ended, sampled span
+type Exporter interface {
+	Export(span *Span)
+}
+
+var exporterLock sync.RWMutex
+var exporter Exporter
+
+// SetExporter installs the exporter, nil stops exporting
+func SetExporter(e Exporter) {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	exporter = e
+}
+
+func export(span *Span) {
+	exporterLock.RLock()
+	e := exporter
+	exporterLock.RUnlock()
+	if nil != e {
+		e.Export(span)
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlpSpan follows the span message of the OTLP JSON encoding
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest is an ExportTraceServiceRequest, as read by the collector's
+// otlpjsonfile receiver
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// WriterExporter writes one OTLP JSON request per span and line, e.g. to
+// stdout or a file
+type WriterExporter struct {
+	lock    sync.Mutex
+	w       io.Writer
+	service string
+}
+
+func NewWriterExporter(w io.Writer, service string) *WriterExporter {
+	return &WriterExporter{w: w, service: service}
+}
+
+func (self *WriterExporter) Export(span *Span) {
+	span.lock.Lock()
+	out := otlpSpan{
+		TraceID:           span.sc.TraceIDString(),
+		SpanID:            span.sc.SpanIDString(),
+		Name:              span.name,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		Status:            otlpStatus{Code: span.status, Message: span.message},
+	}
+	if span.parent != [8]byte{} {
+		out.ParentSpanID = SpanContext{SpanID: span.parent}.SpanIDString()
+	}
+	for key, value := range span.attributes {
+		out.Attributes = append(out.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+	span.lock.Unlock()
+	var scope otlpScopeSpans
+	scope.Scope.Name = self.service
+	scope.Spans = []otlpSpan{out}
+	var resource otlpResourceSpans
+	resource.Resource.Attributes = []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: self.service}}}
+	resource.ScopeSpans = []otlpScopeSpans{scope}
+	line, err := json.Marshal(&otlpRequest{ResourceSpans: []otlpResourceSpans{resource}})
+	if nil != err {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.w.Write(append(line, '\n'))
+}
@@ -0,0 +1,193 @@
+// Package trace carries request ids and W3C trace context through a request
+// and records spans, exported as OTLP JSON lines.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HEADER_REQUEST_ID  = "X-Request-ID"
+	HEADER_TRACEPARENT = "traceparent"
+)
+
+// status codes as in OTLP
+const (
+	STATUS_UNSET = 0
+	STATUS_OK    = 1
+	STATUS_ERROR = 2
+)
+
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (self SpanContext) IsValid() bool {
+	return self.TraceID != [16]byte{} && self.SpanID != [8]byte{}
+}
+
+func (self SpanContext) TraceIDString() string {
+	return hex.EncodeToString(self.TraceID[:])
+}
+
+func (self SpanContext) SpanIDString() string {
+	return hex.EncodeToString(self.SpanID[:])
+}
+
+// Traceparent formats the context as a W3C traceparent header value
+func (self SpanContext) Traceparent() string {
+	flags := "00"
+	if self.Sampled {
+		flags = "01"
+	}
+	return "00-" + self.TraceIDString() + "-" + self.SpanIDString() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || 2 != len(parts[0]) || "ff" == parts[0] || 32 != len(parts[1]) || 16 != len(parts[2]) || 2 != len(parts[3]) {
+		return sc, false
+	}
+	if "00" == parts[0] && 4 != len(parts) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); nil != err {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); nil != err {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if nil != err {
+		return sc, false
+	}
+	sc.Sampled = 0 != flags[0]&1
+	return sc, sc.IsValid()
+}
+
+// Span is one timed operation, End it exactly once
+type Span struct {
+	lock       sync.Mutex
+	name       string
+	sc         SpanContext
+	parent     [8]byte
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	status     int
+	message    string
+	ended      bool
+}
+
+func (self *Span) Context() SpanContext {
+	return self.sc
+}
+
+func (self *Span) SetAttribute(key, value string) {
+	if nil == self {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if nil == self.attributes {
+		self.attributes = make(map[string]string)
+	}
+	self.attributes[key] = value
+}
+
+// SetError marks the span failed when err is not nil
+func (self *Span) SetError(err error) {
+	if nil == self || nil == err {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.status = STATUS_ERROR
+	self.message = err.Error()
+}
+
+func (self *Span) End() {
+	if nil == self {
+		return
+	}
+	self.lock.Lock()
+	if self.ended {
+		self.lock.Unlock()
+		return
+	}
+	self.ended = true
+	self.end = time.Now()
+	self.lock.Unlock()
+	if self.sc.Sampled {
+		export(self)
+	}
+}
+
+type spanKey struct{}
+type requestIDKey struct{}
+
+func randomBytes(b []byte) {
+	rand.Read(b)
+}
+
+// NewSpanContext starts a new sampled trace
+func NewSpanContext() SpanContext {
+	var sc SpanContext
+	randomBytes(sc.TraceID[:])
+	randomBytes(sc.SpanID[:])
+	sc.Sampled = true
+	return sc
+}
+
+// StartRemote starts a span continuing the trace of remote, a new trace
+// when remote is not valid
+func StartRemote(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	span := &Span{name: name, start: time.Now()}
+	if remote.IsValid() {
+		span.sc = remote
+		span.parent = remote.SpanID
+		randomBytes(span.sc.SpanID[:])
+	} else {
+		span.sc = NewSpanContext()
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts a child span of the span in ctx, or a new trace
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	if parent := FromContext(ctx); nil != parent {
+		return StartRemote(ctx, name, parent.sc)
+	}
+	return StartRemote(ctx, name, SpanContext{})
+}
+
+// FromContext returns the current span, nil if none
+func FromContext(ctx context.Context) *Span {
+	if nil == ctx {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id in ctx, empty if none
+func RequestID(ctx context.Context) string {
+	if nil == ctx {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
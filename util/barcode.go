@@ -0,0 +1,146 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/aztec"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/pdf417"
+	"github.com/boombuler/barcode/qr"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+const (
+	BARCODE_QR         = "qr"
+	BARCODE_DATAMATRIX = "datamatrix"
+	BARCODE_AZTEC      = "aztec"
+	BARCODE_PDF417     = "pdf417"
+	BARCODE_CODE128    = "code128"
+)
+
+// BARCODE_LINEAR_HEIGHT is the bar height of linear codes as a share of
+// their width, above the 15% scanners expect
+const BARCODE_LINEAR_HEIGHT = 0.2
+
+// ParseBarcodeKind parses a symbology name
+func ParseBarcodeKind(str string) (string, error) {
+	switch strings.ToLower(str) {
+	case BARCODE_QR:
+		return BARCODE_QR, nil
+	case BARCODE_DATAMATRIX:
+		return BARCODE_DATAMATRIX, nil
+	case BARCODE_AZTEC:
+		return BARCODE_AZTEC, nil
+	case BARCODE_PDF417:
+		return BARCODE_PDF417, nil
+	case BARCODE_CODE128:
+		return BARCODE_CODE128, nil
+	}
+	return "", errors.New("invalid barcode: " + str)
+}
+
+// aztecPercent and pdf417Level map the QR levels to the error correction
+// of Aztec and PDF417, Data Matrix and Code 128 have a fixed one
+var aztecPercent = map[qr.ErrorCorrectionLevel]int{qr.L: 10, qr.M: 23, qr.Q: 36, qr.H: 50}
+var pdf417Level = map[qr.ErrorCorrectionLevel]byte{qr.L: 2, qr.M: 4, qr.Q: 6, qr.H: 8}
+
+// linearCode stretches the single row of a linear barcode into bars
+type linearCode struct {
+	barcode.Barcode
+	height int
+}
+
+func (self linearCode) Bounds() image.Rectangle {
+	b := self.Barcode.Bounds()
+	return image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+self.height)
+}
+
+func (self linearCode) At(x, y int) color.Color {
+	return self.Barcode.At(x, self.Barcode.Bounds().Min.Y)
+}
+
+func encodeBarcode(kind, str string, level qr.ErrorCorrectionLevel) (barcode.Barcode, error) {
+	switch kind {
+	case BARCODE_QR:
+		return qr.Encode(str, level, qr.Unicode)
+	case BARCODE_DATAMATRIX:
+		return datamatrix.Encode(str)
+	case BARCODE_AZTEC:
+		return aztec.Encode([]byte(str), aztecPercent[level], aztec.DEFAULT_LAYERS)
+	case BARCODE_PDF417:
+		return pdf417.Encode(str, pdf417Level[level])
+	case BARCODE_CODE128:
+		code, err := code128.Encode(str)
+		if nil != err {
+			return nil, err
+		}
+		height := int(float64(code.Bounds().Dx()) * BARCODE_LINEAR_HEIGHT)
+		return linearCode{Barcode: code, height: height}, nil
+	}
+	return nil, errors.New("invalid barcode: " + kind)
+}
+
+// ForKind drops the options kind does not support, use it before Key
+func (self QROptions) ForKind(kind string) QROptions {
+	if BARCODE_QR != kind {
+		self.Rounded, self.Logo, self.LogoName, self.LogoHash, self.LogoScale = false, nil, "", "", 0
+	}
+	return self
+}
+
+// BuildBarcode renders str as a kind barcode image in opts.Format. Size is
+// the image width, the height follows the symbol; rounded modules and the
+// logo only apply to QR codes
+func BuildBarcode(kind, str string, opts QROptions) ([]byte, error) {
+	if opts.Size < QR_MIN_SIZE || opts.Size > QR_MAX_SIZE {
+		return nil, errors.New("size must be between " + strconv.Itoa(QR_MIN_SIZE) + " and " + strconv.Itoa(QR_MAX_SIZE))
+	}
+	if opts.Margin < 0 || opts.Margin > QR_MAX_MARGIN {
+		return nil, errors.New("margin must be between 0 and " + strconv.Itoa(QR_MAX_MARGIN))
+	}
+	if opts.ModuleSize < 0 || opts.ModuleSize > QR_MAX_MODULE_SIZE {
+		return nil, errors.New("module size must be between 0 and " + strconv.Itoa(QR_MAX_MODULE_SIZE))
+	}
+	opts = opts.ForKind(kind)
+	if nil != opts.Logo {
+		// the logo hides modules, only level H has the redundancy to spare
+		opts.Level = qr.H
+		if 0 == opts.LogoScale {
+			opts.LogoScale = QR_DEFAULT_LOGO_SCALE
+		}
+		if opts.LogoScale < 0 || opts.LogoScale > QR_MAX_LOGO_SCALE {
+			return nil, errors.New("logo scale must be between 0 and " + formatFloat(QR_MAX_LOGO_SCALE))
+		}
+	}
+	code, err := encodeBarcode(kind, str, opts.Level)
+	if nil != err {
+		return nil, err
+	}
+	switch opts.Format {
+	case QR_FORMAT_SVG:
+		return BuildSvg(code, vectorOptions(code, opts))
+	case QR_FORMAT_PDF:
+		return BuildPdf(code, vectorOptions(code, opts))
+	}
+	img, err := renderImage(code, opts)
+	if nil != err {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if QR_FORMAT_JPG == opts.Format {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 100})
+	} else {
+		err = png.Encode(buf, img)
+	}
+	if nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package util
+
+import (
+	"bytes"
+	"github.com/boombuler/barcode/qr"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/aztec"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"testing"
+)
+
+const testUrl = "https://example.com/some/long/path?with=query&and=more#fragment"
+
+// testShortUrl fits a version 2 symbol at level H, where a logo covers the
+// largest share of the modules
+const testShortUrl = "http://s.io/ab"
+
+// testLogo is a red square with a white cross, busy enough to disturb the
+// modules under it if they were not cleared
+func testLogo() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{R: 0xe0, A: 0xff}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 0, 24, 40), image.White, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 16, 40, 24), image.White, image.Point{}, draw.Src)
+	return img
+}
+
+func brandedOptions() QROptions {
+	opts := DefaultQROptions()
+	opts.Size = 512
+	opts.Foreground = color.RGBA{R: 0x1a, G: 0x23, B: 0x7e, A: 0xff}
+	opts.Background = color.RGBA{R: 0xff, G: 0xf8, B: 0xe1, A: 0xff}
+	return opts
+}
+
+func readerOf(kind string) gozxing.Reader {
+	switch kind {
+	case BARCODE_QR:
+		return qrcode.NewQRCodeReader()
+	case BARCODE_DATAMATRIX:
+		return datamatrix.NewDataMatrixReader()
+	case BARCODE_AZTEC:
+		return aztec.NewAztecReader()
+	case BARCODE_CODE128:
+		return oned.NewCode128Reader()
+	}
+	return nil
+}
+
+func decodeBarcode(t *testing.T, kind string, content []byte) *gozxing.Result {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(content))
+	if nil != err {
+		t.Fatalf("decode png: %v", err)
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if nil != err {
+		t.Fatalf("bitmap: %v", err)
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	result, err := readerOf(kind).Decode(bmp, hints)
+	if nil != err {
+		t.Fatalf("read %s: %v", kind, err)
+	}
+	return result
+}
+
+func TestBrandedQRCodeDecodes(t *testing.T) {
+	cases := []struct {
+		name string
+		opts func(*QROptions)
+	}{
+		{"plain", func(o *QROptions) {}},
+		{"rounded", func(o *QROptions) { o.Rounded = true }},
+		{"logo", func(o *QROptions) { o.Logo, o.LogoName = testLogo(), "test" }},
+		{"rounded logo", func(o *QROptions) { o.Rounded, o.Logo, o.LogoName = true, testLogo(), "test" }},
+		{"largest logo", func(o *QROptions) { o.Rounded, o.Logo, o.LogoScale = true, testLogo(), QR_MAX_LOGO_SCALE }},
+	}
+	for _, str := range []string{testUrl, testShortUrl} {
+		for _, c := range cases {
+			t.Run(c.name+" "+str, func(t *testing.T) {
+				opts := brandedOptions()
+				c.opts(&opts)
+				content, err := BuildQRCode(str, opts)
+				if nil != err {
+					t.Fatalf("build: %v", err)
+				}
+				result := decodeBarcode(t, BARCODE_QR, content)
+				if str != result.GetText() {
+					t.Fatalf("decoded %q, want %q", result.GetText(), str)
+				}
+				level := result.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL]
+				want := opts.Level.String()
+				if nil != opts.Logo {
+					want = qr.H.String()
+				}
+				if want != level {
+					t.Fatalf("level %v, want %s", level, want)
+				}
+			})
+		}
+	}
+}
+
+func TestBrandedQRCodeColors(t *testing.T) {
+	opts := brandedOptions()
+	opts.Logo = testLogo()
+	content, err := BuildQRCode(testUrl, opts)
+	if nil != err {
+		t.Fatalf("build: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(content))
+	if nil != err {
+		t.Fatalf("decode png: %v", err)
+	}
+	seen := make(map[color.RGBA]bool)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			seen[color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)] = true
+		}
+	}
+	for _, c := range []color.RGBA{opts.Foreground, opts.Background, {R: 0xe0, A: 0xff}} {
+		if !seen[c] {
+			t.Fatalf("color %s not drawn", colorHex(c))
+		}
+	}
+}
+
+func TestBarcodeKindsDecode(t *testing.T) {
+	for _, kind := range []string{BARCODE_DATAMATRIX, BARCODE_AZTEC, BARCODE_CODE128} {
+		t.Run(kind, func(t *testing.T) {
+			opts := brandedOptions()
+			opts.Size = 1024
+			// options the other kinds do not support are dropped
+			opts.Rounded, opts.Logo, opts.LogoName = true, testLogo(), "test"
+			content, err := BuildBarcode(kind, testUrl, opts)
+			if nil != err {
+				t.Fatalf("build: %v", err)
+			}
+			result := decodeBarcode(t, kind, content)
+			if testUrl != result.GetText() {
+				t.Fatalf("decoded %q, want %q", result.GetText(), testUrl)
+			}
+		})
+	}
+}
+
+// TestBarcodeModules samples the centre of every module of the image back
+// into a matrix, this covers PDF417 which gozxing has no reader for
+func TestBarcodeModules(t *testing.T) {
+	kinds := []string{BARCODE_QR, BARCODE_DATAMATRIX, BARCODE_AZTEC, BARCODE_PDF417, BARCODE_CODE128}
+	for _, kind := range kinds {
+		t.Run(kind, func(t *testing.T) {
+			opts := brandedOptions()
+			opts.Size = 1024
+			code, err := encodeBarcode(kind, testUrl, opts.Level)
+			if nil != err {
+				t.Fatalf("encode: %v", err)
+			}
+			content, err := BuildBarcode(kind, testUrl, opts)
+			if nil != err {
+				t.Fatalf("build: %v", err)
+			}
+			img, err := png.Decode(bytes.NewReader(content))
+			if nil != err {
+				t.Fatalf("decode png: %v", err)
+			}
+			columns, rows := code.Bounds().Dx(), code.Bounds().Dy()
+			total := columns + 2*opts.Margin
+			scale := opts.Size / total
+			offset := (opts.Size-total*scale)/2 + opts.Margin*scale
+			for y := 0; y < rows; y++ {
+				for x := 0; x < columns; x++ {
+					c := color.RGBAModel.Convert(img.At(offset+x*scale+scale/2, offset+y*scale+scale/2)).(color.RGBA)
+					if (c == opts.Foreground) != isDark(code, x, y) {
+						t.Fatalf("module %d,%d is %s", x, y, colorHex(c))
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PASSWORD_SCHEME     = "pbkdf2-sha256"
+	PASSWORD_ITERATIONS = 600000
+	PASSWORD_SALT_LEN   = 16
+	PASSWORD_KEY_LEN    = 32
+	PASSWORD_MAX_LEN    = 1024
+	// stored hashes may carry more iterations, up to this bound
+	PASSWORD_MAX_ITERATIONS = 10000000
+	// PASSWORD_MAX_RUNS bounds the PBKDF2 runs at once, hashes and checks
+	// alike; a caller waits PASSWORD_RUN_WAIT for a slot
+	PASSWORD_MAX_RUNS = 4
+	PASSWORD_RUN_WAIT = 2 * time.Second
+)
+
+var ErrPasswordBusy = errors.New("too many password hashes running")
+
+var passwordRuns = make(chan struct{}, PASSWORD_MAX_RUNS)
+
+// runPassword runs fn once a slot is free, ErrPasswordBusy when none frees
+// up in time
+func runPassword(ctx context.Context, fn func() error) error {
+	timer := time.NewTimer(PASSWORD_RUN_WAIT)
+	defer timer.Stop()
+	select {
+	case passwordRuns <- struct{}{}:
+	case <-timer.C:
+		return ErrPasswordBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-passwordRuns }()
+	return fn()
+}
+
+// HashPassword returns a salted PBKDF2 hash of password as
+// pbkdf2-sha256$<iterations>$<salt>$<key>, unlike Hash two equal passwords
+// get different hashes
+func HashPassword(ctx context.Context, password string) (string, error) {
+	if "" == password {
+		return "", errors.New("password is empty")
+	}
+	if len(password) > PASSWORD_MAX_LEN {
+		return "", errors.New("password is longer than " + strconv.Itoa(PASSWORD_MAX_LEN))
+	}
+	salt := make([]byte, PASSWORD_SALT_LEN)
+	if _, err := rand.Read(salt); nil != err {
+		return "", err
+	}
+	var key []byte
+	err := runPassword(ctx, func() (err error) {
+		key, err = pbkdf2.Key(sha256.New, password, salt, PASSWORD_ITERATIONS, PASSWORD_KEY_LEN)
+		return err
+	})
+	if nil != err {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return PASSWORD_SCHEME + "$" + strconv.Itoa(PASSWORD_ITERATIONS) + "$" + enc.EncodeToString(salt) + "$" + enc.EncodeToString(key), nil
+}
+
+// CheckPassword reports whether password matches a hash of HashPassword,
+// the error tells the check did not run
+func CheckPassword(ctx context.Context, encoded, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if 4 != len(parts) || PASSWORD_SCHEME != parts[0] || len(password) > PASSWORD_MAX_LEN {
+		return false, nil
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if nil != err || iterations < 1 || iterations > PASSWORD_MAX_ITERATIONS {
+		return false, nil
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if nil != err {
+		return false, nil
+	}
+	want, err := enc.DecodeString(parts[3])
+	if nil != err || 0 == len(want) {
+		return false, nil
+	}
+	var key []byte
+	err = runPassword(ctx, func() (err error) {
+		key, err = pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+		return err
+	})
+	if nil != err {
+		return false, err
+	}
+	return 1 == subtle.ConstantTimeCompare(key, want), nil
+}
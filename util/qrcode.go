@@ -1,43 +1,183 @@
 package util
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"github.com/boombuler/barcode"
 	"github.com/boombuler/barcode/qr"
-	"image/jpeg"
-	"image/png"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
 )
 
-func BuildQRCodePng(str string) ([]byte, error) {
-	code, err := qr.Encode(str, qr.L, qr.Unicode)
-	if nil != err {
-		return nil, err
+const (
+	QR_FORMAT_PNG = "png"
+	QR_FORMAT_JPG = "jpg"
+	QR_FORMAT_SVG = "svg"
+	QR_FORMAT_PDF = "pdf"
+)
+
+const (
+	QR_DEFAULT_SIZE   = 256
+	QR_MIN_SIZE       = 21
+	QR_MAX_SIZE       = 4096
+	QR_DEFAULT_MARGIN = 4
+	QR_MAX_MARGIN     = 64
+	// QR_MAX_MODULE_SIZE bounds ModuleSize, 100 points is well over an inch
+	QR_MAX_MODULE_SIZE = 100
+)
+
+// QROptions describes one rendering of a QR code; Size is the image width
+// in pixels (points for pdf), Margin the quiet zone in modules. ModuleSize,
+// when set, gives the module size of vector formats instead of Size.
+// A Logo forces level H; LogoName and LogoHash, a hash of its file,
+// identify it in Key.
+type QROptions struct {
+	Format     string
+	Size       int
+	Margin     int
+	Level      qr.ErrorCorrectionLevel
+	ModuleSize float64
+	Foreground color.RGBA
+	Background color.RGBA
+	Rounded    bool
+	Logo       image.Image
+	LogoName   string
+	LogoHash   string
+	LogoScale  float64
+}
+
+// Key identifies the rendering for caching, equal keys render equal images
+func (self QROptions) Key() string {
+	if nil != self.Logo {
+		self.Level = qr.H
+	}
+	return fmt.Sprintf("%s/%d/%d/%s/%s/%s/%s/%t/%s@%s/%s", self.Format, self.Size, self.Margin, self.Level,
+		formatFloat(self.ModuleSize), colorHex(self.Foreground), colorHex(self.Background), self.Rounded,
+		self.LogoName, self.LogoHash, formatFloat(self.LogoScale))
+}
+
+func DefaultQROptions() QROptions {
+	return QROptions{
+		Format:     QR_FORMAT_PNG,
+		Size:       QR_DEFAULT_SIZE,
+		Margin:     QR_DEFAULT_MARGIN,
+		Level:      qr.L,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
 	}
-	code, err = barcode.Scale(code, 100, 100)
-	if nil != err {
-		return nil, err
+}
+
+// ParseQRLevel parses an error correction level, L, M, Q or H
+func ParseQRLevel(str string) (qr.ErrorCorrectionLevel, error) {
+	switch strings.ToUpper(str) {
+	case "L":
+		return qr.L, nil
+	case "M":
+		return qr.M, nil
+	case "Q":
+		return qr.Q, nil
+	case "H":
+		return qr.H, nil
 	}
-	buf := new(bytes.Buffer)
-	err = png.Encode(buf, code)
-	if nil != err {
-		return nil, err
+	return qr.L, errors.New("invalid ecc level: " + str)
+}
+
+// ParseQRFormat parses an image format, jpeg is accepted for jpg
+func ParseQRFormat(str string) (string, error) {
+	switch strings.ToLower(str) {
+	case QR_FORMAT_PNG:
+		return QR_FORMAT_PNG, nil
+	case QR_FORMAT_JPG, "jpeg":
+		return QR_FORMAT_JPG, nil
+	case QR_FORMAT_SVG:
+		return QR_FORMAT_SVG, nil
+	case QR_FORMAT_PDF:
+		return QR_FORMAT_PDF, nil
 	}
-	return buf.Bytes(), err
+	return "", errors.New("invalid qr format: " + str)
 }
 
-func BuildQRCodeJpg(str string) ([]byte, error) {
-	code, err := qr.Encode(str, qr.L, qr.Unicode)
-	if nil != err {
-		return nil, err
+// QRContentType returns the mime type of format
+func QRContentType(format string) string {
+	switch format {
+	case QR_FORMAT_JPG:
+		return "image/jpeg"
+	case QR_FORMAT_SVG:
+		return "image/svg+xml"
+	case QR_FORMAT_PDF:
+		return "application/pdf"
+	}
+	return "image/png"
+}
+
+func isDark(code barcode.Barcode, x, y int) bool {
+	r, _, _, _ := code.At(x, y).RGBA()
+	return r < 0x8000
+}
+
+// renderImage draws code with its quiet zone in an image size pixels wide,
+// modules get a whole number of pixels and the rest is spread around
+func renderImage(code barcode.Barcode, opts QROptions) (*image.RGBA, error) {
+	size, margin := opts.Size, opts.Margin
+	columns, rows := code.Bounds().Dx(), code.Bounds().Dy()
+	total := columns + 2*margin
+	scale := size / total
+	if scale < 1 {
+		return nil, errors.New("size too small, need at least " + strconv.Itoa(total) + " pixels")
 	}
-	code, err = barcode.Scale(code, 100, 100)
-	if nil != err {
-		return nil, err
+	padding := (size - total*scale) / 2
+	offset := padding + margin*scale
+	height := (rows+2*margin)*scale + 2*padding
+	img := image.NewRGBA(image.Rect(0, 0, size, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			if isDark(code, x, y) {
+				fillModule(img, offset+x*scale, offset+y*scale, scale, opts.Rounded && !isFinder(code, x, y), opts.Foreground)
+			}
+		}
+	}
+	if nil != opts.Logo {
+		area := image.Rect(offset, offset, offset+columns*scale, offset+rows*scale)
+		drawLogo(img, area, opts.Logo, opts.LogoScale, opts.Background)
+	}
+	return img, nil
+}
+
+// vectorOptions sizes the modules so the whole code is opts.Size wide
+// unless opts.ModuleSize is set
+func vectorOptions(code barcode.Barcode, opts QROptions) VectorOptions {
+	moduleSize := opts.ModuleSize
+	if moduleSize <= 0 {
+		moduleSize = float64(opts.Size) / float64(code.Bounds().Dx()+2*opts.Margin)
 	}
-	buf := new(bytes.Buffer)
-	err = jpeg.Encode(buf, code, &jpeg.Options{100})
-	if nil != err {
-		return nil, err
+	return VectorOptions{
+		ModuleSize: moduleSize,
+		QuietZone:  opts.Margin,
+		Foreground: opts.Foreground,
+		Background: opts.Background,
+		Rounded:    opts.Rounded,
+		Logo:       opts.Logo,
+		LogoScale:  opts.LogoScale,
 	}
-	return buf.Bytes(), err
+}
+
+// BuildQRCode renders str as a QR code image in opts.Format
+func BuildQRCode(str string, opts QROptions) ([]byte, error) {
+	return BuildBarcode(BARCODE_QR, str, opts)
+}
+
+func BuildQRCodePng(str string) ([]byte, error) {
+	opts := DefaultQROptions()
+	opts.Size, opts.Margin = 100, 0
+	return BuildQRCode(str, opts)
+}
+
+func BuildQRCodeJpg(str string) ([]byte, error) {
+	opts := DefaultQROptions()
+	opts.Format, opts.Size, opts.Margin = QR_FORMAT_JPG, 100, 0
+	return BuildQRCode(str, opts)
 }
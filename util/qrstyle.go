@@ -0,0 +1,115 @@
+package util
+
+import (
+	"bytes"
+	"github.com/boombuler/barcode"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+const (
+	// QR_DEFAULT_LOGO_SCALE is the logo width as a share of the symbol
+	// width, quiet zone excluded
+	QR_DEFAULT_LOGO_SCALE = 0.2
+	// QR_MAX_LOGO_SCALE keeps the covered area under what level H recovers,
+	// at 0.3 small and some mid-sized versions no longer decode
+	QR_MAX_LOGO_SCALE = 0.25
+	// QR_MODULE_RADIUS is the corner radius of rounded modules, in modules
+	QR_MODULE_RADIUS = 0.4
+)
+
+// isFinder reports whether module x, y belongs to one of the three finder
+// patterns of a QR code, which stay square so scanners lock on
+func isFinder(code barcode.Barcode, x, y int) bool {
+	if barcode.TypeQR != code.Metadata().CodeKind {
+		return false
+	}
+	n := code.Bounds().Dx()
+	return (x < 7 && y < 7) || (x >= n-7 && y < 7) || (x < 7 && y >= n-7)
+}
+
+// insideRounded reports whether point px, py lies in the square at x, y
+// with side size and corner radius r
+func insideRounded(px, py, x, y, size, r float64) bool {
+	cx := px
+	if px < x+r {
+		cx = x + r
+	} else if px > x+size-r {
+		cx = x + size - r
+	}
+	cy := py
+	if py < y+r {
+		cy = y + r
+	} else if py > y+size-r {
+		cy = y + size - r
+	}
+	return (px-cx)*(px-cx)+(py-cy)*(py-cy) <= r*r
+}
+
+// fillModule paints one module of scale pixels at px, py
+func fillModule(img *image.RGBA, px, py, scale int, rounded bool, c color.RGBA) {
+	// below 3 pixels a rounded module is indistinguishable from a square one
+	if !rounded || scale < 3 {
+		draw.Draw(img, image.Rect(px, py, px+scale, py+scale), &image.Uniform{c}, image.Point{}, draw.Src)
+		return
+	}
+	r := QR_MODULE_RADIUS * float64(scale)
+	for y := 0; y < scale; y++ {
+		for x := 0; x < scale; x++ {
+			if insideRounded(float64(x)+0.5, float64(y)+0.5, 0, 0, float64(scale), r) {
+				img.SetRGBA(px+x, py+y, c)
+			}
+		}
+	}
+}
+
+// logoRect centres a box of scale times width in a width x width area,
+// keeping the aspect ratio of logo
+func logoRect(logo image.Image, width int, scale float64) image.Rectangle {
+	side := int(float64(width) * scale)
+	b := logo.Bounds()
+	w, h := side, side
+	if b.Dx() > b.Dy() {
+		h = side * b.Dy() / b.Dx()
+	} else if b.Dy() > b.Dx() {
+		w = side * b.Dx() / b.Dy()
+	}
+	x, y := (width-w)/2, (width-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// scaleImage resizes src to w x h sampling the nearest pixel
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return dst
+}
+
+// drawLogo clears a padded box in the centre of the symbol, area of img
+// without the quiet zone, and draws logo over it
+func drawLogo(img *image.RGBA, area image.Rectangle, logo image.Image, scale float64, background color.RGBA) {
+	rect := logoRect(logo, area.Dx(), scale).Add(area.Min)
+	if rect.Empty() {
+		return
+	}
+	pad := rect.Dx() / 10
+	draw.Draw(img, rect.Inset(-pad), &image.Uniform{background}, image.Point{}, draw.Src)
+	draw.Draw(img, rect, scaleImage(logo, rect.Dx(), rect.Dy()), image.Point{}, draw.Over)
+}
+
+// encodeLogo returns logo as png for embedding in vector output
+func encodeLogo(logo image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, logo)
+	if nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,269 @@
+package util
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/boombuler/barcode"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// VectorOptions describes a vector rendering of a barcode; ModuleSize is in
+// svg user units or pdf points (1/72 inch), QuietZone in modules. Logo, when
+// set, is drawn in the centre LogoScale times the symbol width.
+type VectorOptions struct {
+	ModuleSize float64
+	QuietZone  int
+	Foreground color.RGBA
+	Background color.RGBA
+	Rounded    bool
+	Logo       image.Image
+	LogoScale  float64
+}
+
+// ParseColor parses #rrggbb, #rgb or either without the #
+func ParseColor(str string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(str, "#")
+	if 3 == len(hex) {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if 6 != len(hex) {
+		return color.RGBA{}, errors.New("invalid color: " + str)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if nil != err {
+		return color.RGBA{}, errors.New("invalid color: " + str)
+	}
+	return color.RGBA{R: uint8(n >> 16), G: uint8(n >> 8), B: uint8(n), A: 0xff}, nil
+}
+
+func colorHex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// moduleRun is a horizontal run of dark modules, in modules from the top left
+// of the barcode without quiet zone
+type moduleRun struct {
+	x, y, width int
+}
+
+// darkRuns merges the dark modules of every row into runs, which keeps the
+// vector output small; with rounded set only modules drawn square are included
+func darkRuns(code barcode.Barcode, rounded bool) []moduleRun {
+	bounds := code.Bounds()
+	var runs []moduleRun
+	for y := 0; y < bounds.Dy(); y++ {
+		start := -1
+		for x := 0; x <= bounds.Dx(); x++ {
+			dark := x < bounds.Dx() && isDark(code, bounds.Min.X+x, bounds.Min.Y+y) && (!rounded || isFinder(code, x, y))
+			if dark && start < 0 {
+				start = x
+			} else if !dark && start >= 0 {
+				runs = append(runs, moduleRun{x: start, y: y, width: x - start})
+				start = -1
+			}
+		}
+	}
+	return runs
+}
+
+// formatFloat keeps 4 decimals, well below what any printer resolves
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(math.Round(f*10000)/10000, 'f', -1, 64)
+}
+
+// roundedModules lists the modules drawn rounded, as runs of width 1
+func roundedModules(code barcode.Barcode, rounded bool) []moduleRun {
+	if !rounded {
+		return nil
+	}
+	bounds := code.Bounds()
+	var modules []moduleRun
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if isDark(code, bounds.Min.X+x, bounds.Min.Y+y) && !isFinder(code, x, y) {
+				modules = append(modules, moduleRun{x: x, y: y, width: 1})
+			}
+		}
+	}
+	return modules
+}
+
+// vectorLogoRect places the logo in module units over a symbol width modules
+// wide after a quiet zone, padded box included
+func vectorLogoRect(logo image.Image, width, quietZone int, scale float64) (x, y, w, h, pad float64) {
+	// lay the logo out on a fine grid, then map back to modules
+	const grid = 1000
+	rect := logoRect(logo, width*grid, scale)
+	x, y = float64(rect.Min.X)/grid+float64(quietZone), float64(rect.Min.Y)/grid+float64(quietZone)
+	w, h = float64(rect.Dx())/grid, float64(rect.Dy())/grid
+	return x, y, w, h, w / 10
+}
+
+// BuildSvg renders code as an svg document
+func BuildSvg(code barcode.Barcode, opts VectorOptions) ([]byte, error) {
+	bounds := code.Bounds()
+	width := bounds.Dx() + 2*opts.QuietZone
+	height := bounds.Dy() + 2*opts.QuietZone
+	buf := new(bytes.Buffer)
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="%s" height="%s" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		formatFloat(float64(width)*opts.ModuleSize), formatFloat(float64(height)*opts.ModuleSize), width, height)
+	if 0 != opts.Background.A {
+		fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, colorHex(opts.Background))
+	}
+	fmt.Fprintf(buf, `<path fill="%s" d="`, colorHex(opts.Foreground))
+	for _, run := range darkRuns(code, opts.Rounded) {
+		fmt.Fprintf(buf, "M%d %dh%dv1h-%dz", run.x+opts.QuietZone, run.y+opts.QuietZone, run.width, run.width)
+	}
+	buf.WriteString(`"/>` + "\n")
+	if modules := roundedModules(code, opts.Rounded); 0 != len(modules) {
+		fmt.Fprintf(buf, `<g fill="%s">`+"\n", colorHex(opts.Foreground))
+		for _, module := range modules {
+			fmt.Fprintf(buf, `<rect x="%d" y="%d" width="1" height="1" rx="%s"/>`+"\n", module.x+opts.QuietZone, module.y+opts.QuietZone, formatFloat(QR_MODULE_RADIUS))
+		}
+		buf.WriteString("</g>\n")
+	}
+	if nil != opts.Logo {
+		data, err := encodeLogo(opts.Logo)
+		if nil != err {
+			return nil, err
+		}
+		x, y, w, h, pad := vectorLogoRect(opts.Logo, bounds.Dx(), opts.QuietZone, opts.LogoScale)
+		fmt.Fprintf(buf, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+			formatFloat(x-pad), formatFloat(y-pad), formatFloat(w+2*pad), formatFloat(h+2*pad), colorHex(opts.Background))
+		// svg 1.1 viewers only know xlink:href, svg 2 ones still read it
+		fmt.Fprintf(buf, `<image x="%s" y="%s" width="%s" height="%s" preserveAspectRatio="none" xlink:href="data:image/png;base64,%s"/>`+"\n",
+			formatFloat(x), formatFloat(y), formatFloat(w), formatFloat(h), base64.StdEncoding.EncodeToString(data))
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
+
+func pdfColor(c color.RGBA) string {
+	return formatFloat(float64(c.R)/255) + " " + formatFloat(float64(c.G)/255) + " " + formatFloat(float64(c.B)/255)
+}
+
+// pdfRoundedRect appends a rounded square path, bezier corners approximate
+// quarter circles
+func pdfRoundedRect(buf *bytes.Buffer, x, y, size, r float64) {
+	k := r * 0.5523
+	f := formatFloat
+	fmt.Fprintf(buf, "%s %s m\n", f(x+r), f(y))
+	fmt.Fprintf(buf, "%s %s l\n", f(x+size-r), f(y))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", f(x+size-r+k), f(y), f(x+size), f(y+r-k), f(x+size), f(y+r))
+	fmt.Fprintf(buf, "%s %s l\n", f(x+size), f(y+size-r))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", f(x+size), f(y+size-r+k), f(x+size-r+k), f(y+size), f(x+size-r), f(y+size))
+	fmt.Fprintf(buf, "%s %s l\n", f(x+r), f(y+size))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", f(x+r-k), f(y+size), f(x), f(y+size-r+k), f(x), f(y+size-r))
+	fmt.Fprintf(buf, "%s %s l\n", f(x), f(y+r))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\nh\n", f(x), f(y+r-k), f(x+r-k), f(y), f(x+r), f(y))
+}
+
+// pdfImage returns the flate compressed image and soft mask streams of img
+func pdfImage(img image.Image) (string, string, error) {
+	b := img.Bounds()
+	rgb := make([]byte, 0, b.Dx()*b.Dy()*3)
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+		}
+	}
+	header := "/Type /XObject /Subtype /Image /Width " + strconv.Itoa(b.Dx()) + " /Height " + strconv.Itoa(b.Dy()) +
+		" /BitsPerComponent 8 /Filter /FlateDecode"
+	mask, err := pdfStream("<< "+header+" /ColorSpace /DeviceGray", alpha)
+	if nil != err {
+		return "", "", err
+	}
+	data, err := pdfStream("<< "+header+" /ColorSpace /DeviceRGB /SMask 6 0 R", rgb)
+	if nil != err {
+		return "", "", err
+	}
+	return data, mask, nil
+}
+
+// pdfStream compresses data into a stream object, dict is left open for
+// the length
+func pdfStream(dict string, data []byte) (string, error) {
+	buf := new(bytes.Buffer)
+	w := zlib.NewWriter(buf)
+	_, err := w.Write(data)
+	if nil == err {
+		err = w.Close()
+	}
+	if nil != err {
+		return "", err
+	}
+	return dict + " /Length " + strconv.Itoa(buf.Len()) + " >>\nstream\n" + buf.String() + "\nendstream", nil
+}
+
+// BuildPdf renders code as a single page pdf exactly the size of the code
+func BuildPdf(code barcode.Barcode, opts VectorOptions) ([]byte, error) {
+	bounds := code.Bounds()
+	m := opts.ModuleSize
+	width := float64(bounds.Dx()+2*opts.QuietZone) * m
+	height := float64(bounds.Dy()+2*opts.QuietZone) * m
+	content := new(bytes.Buffer)
+	if 0 != opts.Background.A {
+		fmt.Fprintf(content, "%s rg\n0 0 %s %s re f\n", pdfColor(opts.Background), formatFloat(width), formatFloat(height))
+	}
+	fmt.Fprintf(content, "%s rg\n", pdfColor(opts.Foreground))
+	for _, run := range darkRuns(code, opts.Rounded) {
+		// pdf puts the origin at the bottom left
+		x := float64(run.x+opts.QuietZone) * m
+		y := height - float64(run.y+opts.QuietZone+1)*m
+		fmt.Fprintf(content, "%s %s %s %s re\n", formatFloat(x), formatFloat(y), formatFloat(float64(run.width)*m), formatFloat(m))
+	}
+	for _, module := range roundedModules(code, opts.Rounded) {
+		x := float64(module.x+opts.QuietZone) * m
+		y := height - float64(module.y+opts.QuietZone+1)*m
+		pdfRoundedRect(content, x, y, m, QR_MODULE_RADIUS*m)
+	}
+	content.WriteString("f\n")
+	resources := "<< >>"
+	var logoObjects []string
+	if nil != opts.Logo {
+		x, y, w, h, pad := vectorLogoRect(opts.Logo, bounds.Dx(), opts.QuietZone, opts.LogoScale)
+		x, y, w, h, pad = x*m, height-(y+h)*m, w*m, h*m, pad*m
+		fmt.Fprintf(content, "%s rg\n%s %s %s %s re f\n", pdfColor(opts.Background),
+			formatFloat(x-pad), formatFloat(y-pad), formatFloat(w+2*pad), formatFloat(h+2*pad))
+		fmt.Fprintf(content, "q %s 0 0 %s %s %s cm /Logo Do Q\n", formatFloat(w), formatFloat(h), formatFloat(x), formatFloat(y))
+		logo, mask, err := pdfImage(opts.Logo)
+		if nil != err {
+			return nil, err
+		}
+		logoObjects = []string{logo, mask}
+		resources = "<< /XObject << /Logo 5 0 R >> >>"
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 " + formatFloat(width) + " " + formatFloat(height) + "] /Resources " + resources + " /Contents 4 0 R >>",
+		"<< /Length " + strconv.Itoa(content.Len()) + " >>\nstream\n" + content.String() + "endstream",
+	}
+	objects = append(objects, logoObjects...)
+	buf := new(bytes.Buffer)
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes(), nil
+}
@@ -50,3 +50,10 @@ func BuildShortUrl(original_url string) string {
 	res, _ := transform(original_url)
 	return res[0]
 }
+
+// BuildShortUrls returns all candidate codes for original_url, the first one
+// equals BuildShortUrl, the rest are fallbacks on collision
+func BuildShortUrls(original_url string) [4]string {
+	res, _ := transform(original_url)
+	return res
+}